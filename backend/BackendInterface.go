@@ -2,10 +2,16 @@ package backend
 
 import (
 	"context"
+	stderrors "errors"
+	"fmt"
 	"io/fs"
 	"time"
 )
 
+// ErrNotSupported is returned by a proxy for an optional operation, like Walk or WriteIfRevision, that the wrapped
+// backend does not implement
+var ErrNotSupported = stderrors.New("not supported by the wrapped backend")
+
 type Backend interface {
 	Exists(ctx context.Context, path string) (bool, error)
 	Get(ctx context.Context, path string) ([]byte, error)
@@ -20,6 +26,151 @@ type FileBackend interface {
 	ListTypes(ctx context.Context, path string, mode fs.FileMode) ([]string, error)
 }
 
+// DeleteDirectoryError is returned by Delete for a directory that is not empty, and for empty directories if the
+// backend does not delete directories at all. The documents in the directory are kept.
+type DeleteDirectoryError struct {
+	Path string
+}
+
+func NewDeleteDirectoryError(path string) *DeleteDirectoryError {
+	return &DeleteDirectoryError{Path: path}
+}
+
+func (d *DeleteDirectoryError) Error() string {
+	return "cannot delete directory " + d.Path
+}
+
 type Proxy interface {
 	SetBackend(backend Backend)
 }
+
+// Revision describes a previous version of a document
+type Revision struct {
+	Revision int       `json:"revision"`
+	Archived time.Time `json:"archived"`
+}
+
+// HistoryBackend is a backend that keeps previous versions of its documents
+type HistoryBackend interface {
+	Backend
+	Revisions(ctx context.Context, path string) ([]Revision, error)
+	GetRevision(ctx context.Context, path string, revision int) ([]byte, error)
+	RestoreRevision(ctx context.Context, path string, revision int) error
+}
+
+// TrashedDocument describes a document that was moved to the trash
+type TrashedDocument struct {
+	Path    string    `json:"path"`
+	Deleted time.Time `json:"deleted"`
+}
+
+// TrashBackend is a backend that moves deleted documents to a trash instead of removing them
+type TrashBackend interface {
+	Backend
+	Trashed(ctx context.Context, path string) ([]TrashedDocument, error)
+	RestoreTrashed(ctx context.Context, path string) error
+	Purge(ctx context.Context, path string) error
+}
+
+// QuotaLimit limits the total size and number of documents below a path prefix. A zero value means no limit.
+type QuotaLimit struct {
+	Prefix       string `json:"prefix"`
+	MaxBytes     int64  `json:"maxBytes,omitempty"`
+	MaxDocuments int64  `json:"maxDocuments,omitempty"`
+}
+
+// QuotaUsage is the current usage of a QuotaLimit
+type QuotaUsage struct {
+	QuotaLimit
+	Bytes     int64 `json:"bytes"`
+	Documents int64 `json:"documents"`
+}
+
+// QuotaBackend is a backend that enforces storage quotas
+type QuotaBackend interface {
+	Backend
+	Usage(ctx context.Context, path string) ([]QuotaUsage, error)
+}
+
+// Transaction stages writes and deletes. Commit applies all of them or none.
+type Transaction interface {
+	Write(path string, data []byte) error
+	Delete(path string) error
+	Commit(ctx context.Context) error
+	Rollback() error
+}
+
+// Transactional is a backend that supports transactions over several documents
+type Transactional interface {
+	Backend
+	Begin(ctx context.Context) (Transaction, error)
+}
+
+// ConditionalBackend is a backend that supports compare-and-swap writes. A revision is an opaque token that changes
+// whenever the content of a document changes.
+type ConditionalBackend interface {
+	Backend
+	// GetWithRevision returns the document together with its current revision
+	GetWithRevision(ctx context.Context, path string) ([]byte, string, error)
+	// WriteIfRevision replaces the document only if its current revision matches revision, otherwise it fails with
+	// errors.ErrorRevisionMismatch. An empty revision only matches a document that does not exist.
+	WriteIfRevision(ctx context.Context, path string, data []byte, revision string) error
+}
+
+// conditional returns the wrapped backend of a proxy as ConditionalBackend, or ErrNotSupported
+func conditional(be Backend) (ConditionalBackend, error) {
+	cbe, ok := be.(ConditionalBackend)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T does not implement backend.ConditionalBackend", ErrNotSupported, be)
+	}
+	return cbe, nil
+}
+
+// DocumentInfo is the metadata of a document
+type DocumentInfo struct {
+	Size       int64             `json:"size"`
+	ModTime    time.Time         `json:"modified"`
+	CreateTime time.Time         `json:"created"`
+	Hash       string            `json:"hash"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+// Stater is a backend that returns the metadata of a document without returning its content
+type Stater interface {
+	Backend
+	Stat(ctx context.Context, path string) (DocumentInfo, error)
+}
+
+// WalkFunc is called by Walk for every document with its absolute path. If it returns an error, the walk stops and
+// returns it.
+type WalkFunc func(path string) error
+
+// Walker is a backend that visits all documents below a prefix. The entries of a directory are visited in lexical
+// order of their names, a subdirectory is walked completely when its name is reached.
+type Walker interface {
+	Backend
+	Walk(ctx context.Context, prefix string, fn WalkFunc) error
+}
+
+// EventType is the kind of change an Event reports
+type EventType string
+
+const (
+	EventCreate EventType = "create"
+	EventUpdate EventType = "update"
+	EventDelete EventType = "delete"
+)
+
+// Event reports a change of a document. For deletes, ModTime is the time of the deletion.
+type Event struct {
+	Type    EventType `json:"type"`
+	Path    string    `json:"path"`
+	ModTime time.Time `json:"modified"`
+}
+
+// Watcher is a backend that reports changes of documents below a prefix. The channel is closed when ctx is done, a
+// ctx that is never done is rejected with ErrWatchNotCancellable.
+type Watcher interface {
+	Backend
+	Watch(ctx context.Context, prefix string) (<-chan Event, error)
+}
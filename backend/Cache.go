@@ -0,0 +1,296 @@
+package backend
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// CacheStats contains the statistics of a Cache
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"`
+}
+
+type cacheEntry struct {
+	path    string
+	tenant  string
+	data    []byte
+	modTime time.Time
+}
+
+// cacheCall is a load of a document that is in flight. Concurrent misses on the same path wait for it instead of
+// reading the document again.
+type cacheCall struct {
+	done  chan struct{}
+	entry *cacheEntry
+	err   error
+}
+
+// wait returns the result of the load, or the error of ctx if it is done first
+func (call *cacheCall) wait(ctx context.Context) (*cacheEntry, error) {
+	select {
+	case <-call.done:
+		return call.entry, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Cache is a proxy that keeps recently read documents and their modification times in memory. The cache is bounded
+// by the total size of the cached documents, the least recently used documents are evicted first. Writes and deletes
+// through the cache invalidate the document. If the wrapped backend implements Watcher, changes made without the cache,
+// e.g. by other processes, invalidate the documents as well.
+//
+// Documents are cached per tenant of the context, see WithTenant, so a cache above a Chroot with TenantPrefix does not
+// return the document of one tenant to another. Invalidating a path drops it for all tenants.
+type Cache struct {
+	Backend   Backend
+	maxBytes  int64
+	stopWatch context.CancelFunc
+
+	mu sync.Mutex
+	// entries and calls are indexed by path and tenant
+	lru       *list.List
+	entries   map[string]map[string]*list.Element
+	calls     map[string]map[string]*cacheCall
+	bytes     int64
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+// NewCache returns a cache that holds at most maxBytes of document data
+func NewCache(maxBytes int64) *Cache {
+	return &Cache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]map[string]*list.Element),
+		calls:    make(map[string]map[string]*cacheCall),
+	}
+}
+
+func (c *Cache) SetBackend(backend Backend) {
+	_ = c.Close()
+	c.Backend = backend
+	if watcher, ok := backend.(Watcher); ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		c.stopWatch = cancel
+		go c.watch(ctx, watcher)
+	}
+}
+
+// watch invalidates the documents the watcher reports as changed. If the watcher disconnects because the cache fell
+// behind, the whole cache is cleared, as changes may have been missed.
+func (c *Cache) watch(ctx context.Context, watcher Watcher) {
+	for {
+		events, err := watcher.Watch(ctx, "/")
+		if err != nil {
+			log.Printf("cache: unable to watch backend, changes made by others are not seen: %v", err)
+			return
+		}
+		for e := range events {
+			c.Invalidate(e.Path)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		c.clear()
+	}
+}
+
+// clear removes all documents from the cache
+func (c *Cache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.entries = make(map[string]map[string]*list.Element)
+	c.calls = make(map[string]map[string]*cacheCall)
+	c.bytes = 0
+}
+
+// Close stops watching the wrapped backend
+func (c *Cache) Close() error {
+	if c.stopWatch != nil {
+		c.stopWatch()
+		c.stopWatch = nil
+	}
+	return nil
+}
+
+// load returns the cached document or reads it from the wrapped backend. The read is shared by all callers that miss
+// the same document, it is not canceled with the context of one of them. Each caller stops waiting when its own
+// context is done.
+func (c *Cache) load(ctx context.Context, path string) (*cacheEntry, error) {
+	key, tenant := cleanPath(path), TenantFromContext(ctx)
+	c.mu.Lock()
+	if element, ok := c.entries[key][tenant]; ok {
+		c.hits++
+		c.lru.MoveToFront(element)
+		c.mu.Unlock()
+		return element.Value.(*cacheEntry), nil
+	}
+	c.misses++
+	if call, ok := c.calls[key][tenant]; ok {
+		c.mu.Unlock()
+		return call.wait(ctx)
+	}
+	call := &cacheCall{done: make(chan struct{})}
+	if c.calls[key] == nil {
+		c.calls[key] = make(map[string]*cacheCall)
+	}
+	c.calls[key][tenant] = call
+	c.mu.Unlock()
+
+	go func(ctx context.Context) {
+		call.entry, call.err = c.read(ctx, path)
+		c.mu.Lock()
+		// an invalidation during the load removes the call, the loaded document may be outdated then
+		if c.calls[key][tenant] == call {
+			delete(c.calls[key], tenant)
+			if len(c.calls[key]) == 0 {
+				delete(c.calls, key)
+			}
+			if call.err == nil {
+				call.entry.path, call.entry.tenant = key, tenant
+				c.add(call.entry)
+			}
+		}
+		c.mu.Unlock()
+		close(call.done)
+	}(context.WithoutCancel(ctx))
+	return call.wait(ctx)
+}
+
+func (c *Cache) read(ctx context.Context, path string) (*cacheEntry, error) {
+	data, err := c.Backend.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	modTime, err := c.Backend.GetLastModified(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheEntry{data: data, modTime: modTime}, nil
+}
+
+// add inserts an entry and evicts the least recently used entries until the cache fits. It must be called with the
+// lock held.
+func (c *Cache) add(entry *cacheEntry) {
+	size := int64(len(entry.data))
+	if size > c.maxBytes {
+		return
+	}
+	if c.entries[entry.path] == nil {
+		c.entries[entry.path] = make(map[string]*list.Element)
+	}
+	c.entries[entry.path][entry.tenant] = c.lru.PushFront(entry)
+	c.bytes += size
+	for c.bytes > c.maxBytes {
+		c.remove(c.lru.Back())
+		c.evictions++
+	}
+}
+
+// remove must be called with the lock held
+func (c *Cache) remove(element *list.Element) {
+	entry := c.lru.Remove(element).(*cacheEntry)
+	delete(c.entries[entry.path], entry.tenant)
+	if len(c.entries[entry.path]) == 0 {
+		delete(c.entries, entry.path)
+	}
+	c.bytes -= int64(len(entry.data))
+}
+
+// Invalidate removes the document from the cache for all tenants
+func (c *Cache) Invalidate(path string) {
+	key := cleanPath(path)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, element := range c.entries[key] {
+		c.remove(element)
+	}
+	delete(c.calls, key)
+}
+
+// Stats returns the hit and miss statistics and the current size of the cache
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Entries:   c.lru.Len(),
+		Bytes:     c.bytes,
+	}
+}
+
+func (c *Cache) Exists(ctx context.Context, path string) (bool, error) {
+	c.mu.Lock()
+	_, ok := c.entries[cleanPath(path)][TenantFromContext(ctx)]
+	c.mu.Unlock()
+	if ok {
+		return true, nil
+	}
+	return c.Backend.Exists(ctx, path)
+}
+
+func (c *Cache) Get(ctx context.Context, path string) ([]byte, error) {
+	entry, err := c.load(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	// the entry is shared, a caller that modifies the result must not change it for everyone else
+	return bytes.Clone(entry.data), nil
+}
+
+func (c *Cache) Write(ctx context.Context, path string, data []byte) error {
+	defer c.Invalidate(path)
+	return c.Backend.Write(ctx, path, data)
+}
+
+// GetWithRevision bypasses the cache, so the revision is the current one of the wrapped backend
+func (c *Cache) GetWithRevision(ctx context.Context, path string) ([]byte, string, error) {
+	cbe, err := conditional(c.Backend)
+	if err != nil {
+		return nil, "", err
+	}
+	return cbe.GetWithRevision(ctx, path)
+}
+
+func (c *Cache) WriteIfRevision(ctx context.Context, path string, data []byte, revision string) error {
+	cbe, err := conditional(c.Backend)
+	if err != nil {
+		return err
+	}
+	defer c.Invalidate(path)
+	return cbe.WriteIfRevision(ctx, path, data, revision)
+}
+
+func (c *Cache) Walk(ctx context.Context, prefix string, fn WalkFunc) error {
+	return walkTree(ctx, c.Backend, prefix, fn)
+}
+
+func (c *Cache) Delete(ctx context.Context, path string) error {
+	defer c.Invalidate(path)
+	return c.Backend.Delete(ctx, path)
+}
+
+func (c *Cache) List(ctx context.Context, path string) ([]string, error) {
+	return c.Backend.List(ctx, path)
+}
+
+func (c *Cache) GetLastModified(ctx context.Context, path string) (time.Time, error) {
+	entry, err := c.load(ctx, path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return entry.modTime, nil
+}
@@ -0,0 +1,148 @@
+package backend_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/skroczek/go-simple-json-store/backend"
+	"github.com/skroczek/go-simple-json-store/backend/fs"
+)
+
+// slowBackend counts the reads and delays them, so concurrent reads overlap
+type slowBackend struct {
+	backend.Backend
+	reads atomic.Int32
+}
+
+func (s *slowBackend) Get(ctx context.Context, path string) ([]byte, error) {
+	s.reads.Add(1)
+	time.Sleep(10 * time.Millisecond)
+	return s.Backend.Get(ctx, path)
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.TODO()
+	slow := &slowBackend{Backend: fs.NewMemory()}
+	cache := backend.NewCache(10)
+	cache.SetBackend(slow)
+	_ = cache.Write(ctx, "/a.json", []byte("aaaa"))
+	_ = cache.Write(ctx, "/b.json", []byte("bbbb"))
+	_ = cache.Write(ctx, "/c.json", []byte("cccc"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if data, err := cache.Get(ctx, "/a.json"); err != nil || string(data) != "aaaa" {
+				t.Errorf("Get() got = %s, %v", data, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if reads := slow.reads.Load(); reads != 1 {
+		t.Errorf("concurrent misses caused %d reads, want 1", reads)
+	}
+
+	_, _ = cache.Get(ctx, "/b.json")
+	_, _ = cache.Get(ctx, "/a.json")
+	// exceeds 10 bytes, b.json is the least recently used document
+	_, _ = cache.Get(ctx, "/c.json")
+	stats := cache.Stats()
+	if stats.Entries != 2 || stats.Bytes != 8 || stats.Evictions != 1 || stats.Hits < 1 {
+		t.Errorf("Stats() got = %+v", stats)
+	}
+	reads := slow.reads.Load()
+	_, _ = cache.Get(ctx, "/a.json")
+	if slow.reads.Load() != reads {
+		t.Errorf("Get() of cached document read the backend")
+	}
+
+	_ = cache.Write(ctx, "/a.json", []byte("new"))
+	if data, _ := cache.Get(ctx, "/a.json"); string(data) != "new" {
+		t.Errorf("Get() after Write() got = %s, want new", data)
+	}
+	_ = cache.Delete(ctx, "/a.json")
+	if _, err := cache.Get(ctx, "/a.json"); err == nil {
+		t.Errorf("Get() after Delete() returned cached document")
+	}
+}
+
+func TestCache_GetReturnsCopy(t *testing.T) {
+	ctx := context.TODO()
+	cache := backend.NewCache(10)
+	cache.SetBackend(fs.NewMemory())
+	_ = cache.Write(ctx, "/a.json", []byte("aaaa"))
+	data, _ := cache.Get(ctx, "/a.json")
+	data[0] = 'x'
+	if data, _ := cache.Get(ctx, "/a.json"); string(data) != "aaaa" {
+		t.Errorf("Get() after modifying a previous result got = %s, want aaaa", data)
+	}
+}
+
+// blockingBackend blocks reads until release is closed or the context of the read is done
+type blockingBackend struct {
+	backend.Backend
+	release chan struct{}
+}
+
+func (b *blockingBackend) Get(ctx context.Context, path string) ([]byte, error) {
+	select {
+	case <-b.release:
+		return b.Backend.Get(ctx, path)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestCache_CanceledCaller(t *testing.T) {
+	blocking := &blockingBackend{Backend: fs.NewMemory(), release: make(chan struct{})}
+	_ = blocking.Write(context.TODO(), "/a.json", []byte("aaaa"))
+	cache := backend.NewCache(10)
+	cache.SetBackend(blocking)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := cache.Get(ctx, "/a.json")
+		first <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	second := make(chan []byte)
+	go func() {
+		data, _ := cache.Get(context.TODO(), "/a.json")
+		second <- data
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Errorf("Get() with canceled context error = %v, want %v", err, context.Canceled)
+	}
+	close(blocking.release)
+	if data := <-second; string(data) != "aaaa" {
+		t.Errorf("Get() waiting for the read of a canceled caller got = %s, want aaaa", data)
+	}
+}
+
+func TestCache_Tenants(t *testing.T) {
+	chroot := backend.NewChrootFunc(backend.TenantPrefix("/tenants"))
+	chroot.SetBackend(fs.NewMemory())
+	cache := backend.NewCache(100)
+	cache.SetBackend(chroot)
+	for _, tenant := range []string{"alice", "bob"} {
+		ctx := backend.WithTenant(context.TODO(), tenant)
+		_ = cache.Write(ctx, "/a.json", []byte(tenant))
+		if data, err := cache.Get(ctx, "/a.json"); err != nil || string(data) != tenant {
+			t.Errorf("Get() as %s got = %s, %v", tenant, data, err)
+		}
+	}
+	if data, _ := cache.Get(backend.WithTenant(context.TODO(), "alice"), "/a.json"); string(data) != "alice" {
+		t.Errorf("Get() as alice got = %s, want alice", data)
+	}
+	if ok, _ := cache.Exists(backend.WithTenant(context.TODO(), "carol"), "/a.json"); ok {
+		t.Errorf("Exists() as carol reported the document of another tenant")
+	}
+}
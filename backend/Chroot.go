@@ -0,0 +1,233 @@
+package backend
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/skroczek/go-simple-json-store/errors"
+)
+
+// tenantKey is the context key under which WithTenant stores the tenant of a request
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx that carries tenant, see TenantPrefix and TenantKeyProvider
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant stored by WithTenant, or "" if ctx carries none
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// ErrNoTenant is returned by TenantPrefix if the context carries no tenant
+var ErrNoTenant = stderrors.New("no tenant")
+
+// tenantPattern is what a tenant name may look like. It is a single path segment that cannot be . or ..
+var tenantPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// PrefixFunc returns the prefix a Chroot confines the operations of ctx to
+type PrefixFunc func(ctx context.Context) (string, error)
+
+// StaticPrefix returns a PrefixFunc that always returns prefix
+func StaticPrefix(prefix string) PrefixFunc {
+	return func(ctx context.Context) (string, error) {
+		return prefix, nil
+	}
+}
+
+// TenantPrefix returns a PrefixFunc that confines each request to root/<tenant>. The tenant is read from the context
+// with TenantFromContext and must be a valid tenant name.
+func TenantPrefix(root string) PrefixFunc {
+	return func(ctx context.Context) (string, error) {
+		tenant := TenantFromContext(ctx)
+		if tenant == "" {
+			return "", ErrNoTenant
+		}
+		if !ValidTenant(tenant) {
+			return "", errors.ErrorInvalidPath
+		}
+		return root + "/" + tenant, nil
+	}
+}
+
+// ValidTenant reports whether name can be used as tenant
+func ValidTenant(name string) bool {
+	return tenantPattern.MatchString(name) && !strings.Contains(name, "..")
+}
+
+// checkPath rejects paths that could leave the directory they are joined to: . and .. segments, backslashes, NUL
+// bytes and percent-encoded separators and dots that a later decoding step could turn into them.
+func checkPath(path string) error {
+	if strings.ContainsAny(path, "\\\x00") {
+		return errors.ErrorInvalidPath
+	}
+	lower := strings.ToLower(path)
+	for _, encoded := range []string{"%2f", "%2e", "%5c", "%00"} {
+		if strings.Contains(lower, encoded) {
+			return errors.ErrorInvalidPath
+		}
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "." || segment == ".." {
+			return errors.ErrorInvalidPath
+		}
+	}
+	return nil
+}
+
+// Chroot is a proxy that confines all operations to a prefix of the wrapped backend, like chroot does for processes.
+// Paths that could escape the prefix are rejected with errors.ErrorInvalidPath, and the prefix is removed from the
+// errors of the wrapped backend, so callers learn nothing about the paths outside their root.
+type Chroot struct {
+	Backend Backend
+	prefix  PrefixFunc
+}
+
+// NewChroot returns a Chroot that confines all operations to prefix
+func NewChroot(prefix string) *Chroot {
+	return NewChrootFunc(StaticPrefix(prefix))
+}
+
+// NewChrootFunc returns a Chroot that determines the prefix per operation, e.g. with TenantPrefix
+func NewChrootFunc(prefix PrefixFunc) *Chroot {
+	return &Chroot{prefix: prefix}
+}
+
+func (c *Chroot) SetBackend(backend Backend) {
+	c.Backend = backend
+}
+
+// resolve returns the path in the wrapped backend and the prefix it was joined with
+func (c *Chroot) resolve(ctx context.Context, path string) (string, string, error) {
+	if err := checkPath(path); err != nil {
+		return "", "", err
+	}
+	prefix, err := c.prefix(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	prefix = strings.TrimSuffix(cleanPath(prefix), "/")
+	if err = checkPath(prefix); err != nil {
+		return "", "", fmt.Errorf("invalid chroot prefix: %w", err)
+	}
+	return prefix + cleanPath(path), prefix, nil
+}
+
+// resolveDocument is resolve for document paths. The wrapped backend would accept names that are too short once the
+// prefix is added, so they are rejected here.
+func (c *Chroot) resolveDocument(ctx context.Context, path string) (string, string, error) {
+	if len(strings.Trim(path, "/")) < 6 {
+		return "", "", errors.ErrorInvalidPath
+	}
+	return c.resolve(ctx, path)
+}
+
+// chrootError hides the prefix in the message of an error of the wrapped backend
+type chrootError struct {
+	err     error
+	message string
+}
+
+func (e *chrootError) Error() string {
+	return e.message
+}
+
+func (e *chrootError) Unwrap() error {
+	return e.err
+}
+
+// sanitize removes prefix from err. The sentinel errors of the errors package and os.ErrNotExist are returned as they
+// are, because callers compare them directly.
+func sanitize(err error, path, prefix string) error {
+	if err == nil || err == os.ErrNotExist || errors.IsClientError(err) {
+		return err
+	}
+	var pathError *fs.PathError
+	if stderrors.As(err, &pathError) {
+		return &fs.PathError{Op: pathError.Op, Path: path, Err: pathError.Err}
+	}
+	message := err.Error()
+	for _, p := range []string{prefix, strings.TrimPrefix(prefix, "/")} {
+		if p != "" {
+			message = strings.ReplaceAll(message, p, "")
+		}
+	}
+	return &chrootError{err: err, message: message}
+}
+
+func (c *Chroot) Exists(ctx context.Context, path string) (bool, error) {
+	full, prefix, err := c.resolveDocument(ctx, path)
+	if err != nil {
+		return false, err
+	}
+	exists, err := c.Backend.Exists(ctx, full)
+	return exists, sanitize(err, path, prefix)
+}
+
+func (c *Chroot) Get(ctx context.Context, path string) ([]byte, error) {
+	full, prefix, err := c.resolveDocument(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.Backend.Get(ctx, full)
+	return data, sanitize(err, path, prefix)
+}
+
+func (c *Chroot) Write(ctx context.Context, path string, data []byte) error {
+	full, prefix, err := c.resolveDocument(ctx, path)
+	if err != nil {
+		return err
+	}
+	return sanitize(c.Backend.Write(ctx, full, data), path, prefix)
+}
+
+// Delete deletes a document. The root of the chroot itself cannot be deleted.
+func (c *Chroot) Delete(ctx context.Context, path string) error {
+	if cleanPath(path) == "/" {
+		return errors.ErrorInvalidPath
+	}
+	full, prefix, err := c.resolve(ctx, path)
+	if err != nil {
+		return err
+	}
+	return sanitize(c.Backend.Delete(ctx, full), path, prefix)
+}
+
+func (c *Chroot) List(ctx context.Context, path string) ([]string, error) {
+	full, prefix, err := c.resolve(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	list, err := c.Backend.List(ctx, full)
+	return list, sanitize(err, path, prefix)
+}
+
+func (c *Chroot) ListTypes(ctx context.Context, path string, mode fs.FileMode) ([]string, error) {
+	fbe, ok := c.Backend.(FileBackend)
+	if !ok {
+		return nil, fmt.Errorf("wrapped backend does not implement backend.FileBackend")
+	}
+	full, prefix, err := c.resolve(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	list, err := fbe.ListTypes(ctx, full, mode)
+	return list, sanitize(err, path, prefix)
+}
+
+func (c *Chroot) GetLastModified(ctx context.Context, path string) (time.Time, error) {
+	full, prefix, err := c.resolveDocument(ctx, path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	modTime, err := c.Backend.GetLastModified(ctx, full)
+	return modTime, sanitize(err, path, prefix)
+}
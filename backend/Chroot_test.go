@@ -0,0 +1,99 @@
+package backend_test
+
+import (
+	"context"
+	stderrors "errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/skroczek/go-simple-json-store/backend"
+	"github.com/skroczek/go-simple-json-store/backend/backendtest"
+	"github.com/skroczek/go-simple-json-store/backend/fs"
+	"github.com/skroczek/go-simple-json-store/errors"
+)
+
+func TestChroot_Conformance(t *testing.T) {
+	backendtest.RunConformance(t, func(t *testing.T) backend.Backend {
+		c := backend.NewChroot("/tenants/acme")
+		c.SetBackend(fs.NewFilesystemBackend(t.TempDir(), fs.WithCreateDirs()))
+		return c
+	})
+}
+
+func TestChroot_Escape(t *testing.T) {
+	ctx := context.TODO()
+	be := fs.NewMemory()
+	_ = be.Write(ctx, "/secret.json", []byte("{}"))
+	c := backend.NewChroot("/tenants/acme")
+	c.SetBackend(be)
+	for _, path := range []string{
+		"/../secret.json",
+		"/foo/../../secret.json",
+		"/./secret.json",
+		"/..%2fsecret.json",
+		"/%2E%2E/secret.json",
+		"/..\\secret.json",
+		"/foo\x00.json",
+	} {
+		if _, err := c.Get(ctx, path); err != errors.ErrorInvalidPath {
+			t.Errorf("Get(%q) error = %v, want %v", path, err, errors.ErrorInvalidPath)
+		}
+		if err := c.Write(ctx, path, []byte("{}")); err != errors.ErrorInvalidPath {
+			t.Errorf("Write(%q) error = %v, want %v", path, err, errors.ErrorInvalidPath)
+		}
+	}
+	if err := c.Delete(ctx, "/"); err != errors.ErrorInvalidPath {
+		t.Errorf("Delete(/) error = %v, want %v", err, errors.ErrorInvalidPath)
+	}
+}
+
+func TestChroot_ErrorsHidePrefix(t *testing.T) {
+	ctx := context.TODO()
+	root := t.TempDir()
+	c := backend.NewChroot("/tenants/acme")
+	c.SetBackend(fs.NewFilesystemBackend(root, fs.WithCreateDirs()))
+	_ = c.Write(ctx, "/foo/bar.json", []byte("{}"))
+
+	_, err := c.Get(ctx, "/missing.json")
+	if !os.IsNotExist(err) {
+		t.Errorf("Get() error = %v, want not exist", err)
+	}
+	if err := c.Delete(ctx, "/foo"); err == nil {
+		t.Errorf("Delete() of directory error = nil")
+	} else {
+		var deleteDirectoryError *fs.DeleteDirectoryError
+		if !stderrors.As(err, &deleteDirectoryError) {
+			t.Errorf("Delete() of directory error = %T, want it to wrap *fs.DeleteDirectoryError", err)
+		}
+	}
+	for _, err := range []error{err, c.Delete(ctx, "/foo")} {
+		if strings.Contains(err.Error(), "tenants") || strings.Contains(err.Error(), root) {
+			t.Errorf("error %q reveals the prefix", err)
+		}
+	}
+}
+
+func TestChroot_Tenants(t *testing.T) {
+	be := fs.NewMemory()
+	c := backend.NewChrootFunc(backend.TenantPrefix("/tenants"))
+	c.SetBackend(be)
+	acme := backend.WithTenant(context.TODO(), "acme")
+	other := backend.WithTenant(context.TODO(), "other")
+	if err := c.Write(acme, "/foo.json", []byte(`"acme"`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(other, "/foo.json"); !os.IsNotExist(err) {
+		t.Errorf("Get() of other tenant error = %v, want not exist", err)
+	}
+	if data, _ := be.Get(context.TODO(), "/tenants/acme/foo.json"); string(data) != `"acme"` {
+		t.Errorf("document stored at wrong path, got %s", data)
+	}
+	if _, err := c.Get(context.TODO(), "/foo.json"); err != backend.ErrNoTenant {
+		t.Errorf("Get() without tenant error = %v, want %v", err, backend.ErrNoTenant)
+	}
+	invalid := backend.WithTenant(context.TODO(), "..")
+	if _, err := c.Get(invalid, "/foo.json"); err != errors.ErrorInvalidPath {
+		t.Errorf("Get() with tenant .. error = %v, want %v", err, errors.ErrorInvalidPath)
+	}
+}
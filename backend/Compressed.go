@@ -0,0 +1,194 @@
+package backend
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// compressionMagic starts every document written by Compressed that is not stored raw. The byte after it is the
+// algorithm.
+var compressionMagic = []byte("GSJZ")
+
+type CompressionAlgorithm byte
+
+const (
+	// CompressionStored marks data that is stored uncompressed but would otherwise be mistaken for compressed data
+	CompressionStored CompressionAlgorithm = iota
+	CompressionGzip
+	CompressionFlate
+)
+
+type CompressedOption func(*Compressed)
+
+// WithCompressionAlgorithm sets the algorithm for new documents, the default is CompressionGzip
+func WithCompressionAlgorithm(algorithm CompressionAlgorithm) CompressedOption {
+	return func(c *Compressed) {
+		c.algorithm = algorithm
+	}
+}
+
+// WithCompressionLevel sets the compression level, see compress/flate
+func WithCompressionLevel(level int) CompressedOption {
+	return func(c *Compressed) {
+		c.level = level
+	}
+}
+
+// WithMinCompressionSize sets the size in bytes below which documents are stored uncompressed
+func WithMinCompressionSize(size int) CompressedOption {
+	return func(c *Compressed) {
+		c.minSize = size
+	}
+}
+
+// Compressed is a proxy that compresses documents before they reach the wrapped backend. Compressed documents start
+// with a header, documents without it are returned as they are. Uncompressed documents written before the proxy was
+// added therefore stay readable.
+type Compressed struct {
+	Backend   Backend
+	algorithm CompressionAlgorithm
+	level     int
+	minSize   int
+}
+
+func NewCompressed(options ...CompressedOption) *Compressed {
+	c := &Compressed{algorithm: CompressionGzip, level: flate.DefaultCompression, minSize: 256}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+func (c *Compressed) SetBackend(backend Backend) {
+	c.Backend = backend
+}
+
+func (c *Compressed) compress(data []byte) ([]byte, error) {
+	if len(data) >= c.minSize && c.algorithm != CompressionStored {
+		var buf bytes.Buffer
+		buf.Write(compressionMagic)
+		buf.WriteByte(byte(c.algorithm))
+		var w io.WriteCloser
+		var err error
+		switch c.algorithm {
+		case CompressionGzip:
+			w, err = gzip.NewWriterLevel(&buf, c.level)
+		case CompressionFlate:
+			w, err = flate.NewWriter(&buf, c.level)
+		default:
+			err = fmt.Errorf("unknown compression algorithm %d", c.algorithm)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err = w.Write(data); err != nil {
+			return nil, err
+		}
+		if err = w.Close(); err != nil {
+			return nil, err
+		}
+		if buf.Len() < len(data) {
+			return buf.Bytes(), nil
+		}
+	}
+	if !bytes.HasPrefix(data, compressionMagic) {
+		return data, nil
+	}
+	// e.g. ciphertext that happens to start with the magic bytes
+	stored := make([]byte, 0, len(compressionMagic)+1+len(data))
+	stored = append(stored, compressionMagic...)
+	stored = append(stored, byte(CompressionStored))
+	return append(stored, data...), nil
+}
+
+func decompress(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, compressionMagic) || len(data) <= len(compressionMagic) {
+		return data, nil
+	}
+	algorithm := CompressionAlgorithm(data[len(compressionMagic)])
+	payload := data[len(compressionMagic)+1:]
+	var r io.ReadCloser
+	var err error
+	switch algorithm {
+	case CompressionStored:
+		return payload, nil
+	case CompressionGzip:
+		r, err = gzip.NewReader(bytes.NewReader(payload))
+	case CompressionFlate:
+		r = flate.NewReader(bytes.NewReader(payload))
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm %d", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func (c *Compressed) Exists(ctx context.Context, path string) (bool, error) {
+	return c.Backend.Exists(ctx, path)
+}
+
+func (c *Compressed) Get(ctx context.Context, path string) ([]byte, error) {
+	data, err := c.Backend.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return decompress(data)
+}
+
+func (c *Compressed) Write(ctx context.Context, path string, data []byte) error {
+	compressed, err := c.compress(data)
+	if err != nil {
+		return err
+	}
+	return c.Backend.Write(ctx, path, compressed)
+}
+
+// GetWithRevision returns the decompressed document and the revision of the stored data, see ConditionalBackend
+func (c *Compressed) GetWithRevision(ctx context.Context, path string) ([]byte, string, error) {
+	cbe, err := conditional(c.Backend)
+	if err != nil {
+		return nil, "", err
+	}
+	data, revision, err := cbe.GetWithRevision(ctx, path)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err = decompress(data)
+	return data, revision, err
+}
+
+func (c *Compressed) WriteIfRevision(ctx context.Context, path string, data []byte, revision string) error {
+	cbe, err := conditional(c.Backend)
+	if err != nil {
+		return err
+	}
+	compressed, err := c.compress(data)
+	if err != nil {
+		return err
+	}
+	return cbe.WriteIfRevision(ctx, path, compressed, revision)
+}
+
+func (c *Compressed) Walk(ctx context.Context, prefix string, fn WalkFunc) error {
+	return walkTree(ctx, c.Backend, prefix, fn)
+}
+
+func (c *Compressed) Delete(ctx context.Context, path string) error {
+	return c.Backend.Delete(ctx, path)
+}
+
+func (c *Compressed) List(ctx context.Context, path string) ([]string, error) {
+	return c.Backend.List(ctx, path)
+}
+
+func (c *Compressed) GetLastModified(ctx context.Context, path string) (time.Time, error) {
+	return c.Backend.GetLastModified(ctx, path)
+}
@@ -0,0 +1,81 @@
+package backend_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/skroczek/go-simple-json-store/backend"
+	"github.com/skroczek/go-simple-json-store/backend/fs"
+)
+
+func TestCompressed(t *testing.T) {
+	ctx := context.TODO()
+	large := []byte(`[` + strings.Repeat(`{"name":"John Doe","age":42},`, 100) + `{}]`)
+	tests := []struct {
+		name string
+		data []byte
+		// raw reports whether the data must reach the wrapped backend unchanged
+		raw bool
+	}{
+		{"small", []byte(`{"name":"foo"}`), true},
+		{"large", large, false},
+		{"magic prefix", []byte("GSJZ\x01 not compressed"), false},
+	}
+	for _, algorithm := range []backend.CompressionAlgorithm{backend.CompressionGzip, backend.CompressionFlate} {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				mem := fs.NewMemory()
+				c := backend.NewCompressed(backend.WithCompressionAlgorithm(algorithm))
+				c.SetBackend(mem)
+				if err := c.Write(ctx, "/foo.json", tt.data); err != nil {
+					t.Fatalf("Write() error = %v", err)
+				}
+				stored, _ := mem.Get(ctx, "/foo.json")
+				if bytes.Equal(stored, tt.data) != tt.raw {
+					t.Errorf("stored data raw = %v, want %v", !tt.raw, tt.raw)
+				}
+				if !tt.raw && len(tt.data) > 1000 && len(stored) >= len(tt.data)/4 {
+					t.Errorf("stored %d bytes for %d bytes of data", len(stored), len(tt.data))
+				}
+				got, err := c.Get(ctx, "/foo.json")
+				if err != nil || !bytes.Equal(got, tt.data) {
+					t.Errorf("Get() got = %s, %v", got, err)
+				}
+			})
+		}
+	}
+}
+
+func TestCompressed_Uncompressed(t *testing.T) {
+	ctx := context.TODO()
+	mem := fs.NewMemory()
+	_ = mem.Write(ctx, "/foo.json", []byte(`{"legacy":true}`))
+	c := backend.NewCompressed()
+	c.SetBackend(mem)
+	if got, err := c.Get(ctx, "/foo.json"); err != nil || string(got) != `{"legacy":true}` {
+		t.Errorf("Get() of uncompressed document got = %s, %v", got, err)
+	}
+}
+
+func TestCompressed_Encrypted(t *testing.T) {
+	ctx := context.TODO()
+	keys := backend.StaticKeyProvider("test", []byte("secret"))
+	data := []byte(strings.Repeat(`{"name":"John Doe"}`, 100))
+
+	compressed := backend.NewCompressed(backend.WithMinCompressionSize(0))
+	compressed.SetBackend(backend.NewEncrypted(fs.NewMemory(), keys))
+	inner := backend.NewCompressed(backend.WithMinCompressionSize(0))
+	inner.SetBackend(fs.NewMemory())
+	encrypted := backend.NewEncrypted(inner, keys)
+
+	for name, be := range map[string]backend.Backend{"compressed over encrypted": compressed, "encrypted over compressed": encrypted} {
+		if err := be.Write(ctx, "/foo.json", data); err != nil {
+			t.Fatalf("%s: Write() error = %v", name, err)
+		}
+		if got, err := be.Get(ctx, "/foo.json"); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s: Get() got = %.20s, %v", name, got, err)
+		}
+	}
+}
@@ -2,44 +2,197 @@ package backend
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/skroczek/go-simple-json-store/errors"
 	"github.com/skroczek/go-simple-json-store/helper"
+	"os"
+	"sync"
 	"time"
 )
 
+// saltPath is the document that holds the salt of the store. It is not encrypted, the salt is not secret. Encrypted
+// writes it to the wrapped backend directly and rejects it as path of its own operations.
+const saltPath = "/.encryption-salt.json"
+
+// checkSaltPath rejects the path of the salt document with errors.ErrorInvalidPath
+func checkSaltPath(path string) error {
+	if cleanPath(path) == saltPath {
+		return errors.ErrorInvalidPath
+	}
+	return nil
+}
+
+// storeSalt is the content of the document at saltPath
+type storeSalt struct {
+	Salt []byte `json:"salt"`
+}
+
+// Encrypted encrypts documents with the keys of a KeyProvider before they are written to the wrapped backend. All
+// documents of a store are sealed with the same salt, which is kept in the store, so every key is only derived once.
 type Encrypted struct {
 	Backend Backend
+	Keys    KeyProvider
+
+	mu   sync.Mutex
+	salt []byte
 }
 
-func NewEncrypted(backend Backend) *Encrypted {
-	return &Encrypted{Backend: backend}
+// NewEncrypted returns an Encrypted backend that uses keys. Use DefaultKeyProvider for the passphrase in the
+// environment variable PassphraseEnv.
+func NewEncrypted(backend Backend, keys KeyProvider) *Encrypted {
+	return &Encrypted{Backend: backend, Keys: keys}
 }
 
 func (e *Encrypted) SetBackend(backend Backend) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	e.Backend = backend
+	e.salt = nil
+}
+
+// storeSalt returns the salt of the store, which is created on the first write
+func (e *Encrypted) storeSalt(ctx context.Context) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.salt != nil {
+		return e.salt, nil
+	}
+	for {
+		data, err := e.Backend.Get(ctx, saltPath)
+		if err == nil {
+			var stored storeSalt
+			if err = json.Unmarshal(data, &stored); err != nil || len(stored.Salt) == 0 {
+				return nil, fmt.Errorf("invalid salt in %s: %v", saltPath, err)
+			}
+			e.salt = stored.Salt
+			return e.salt, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		salt, err := helper.NewSalt()
+		if err != nil {
+			return nil, err
+		}
+		data, err = json.Marshal(storeSalt{Salt: salt})
+		if err != nil {
+			return nil, err
+		}
+		if cbe, ok := e.Backend.(ConditionalBackend); ok {
+			err = cbe.WriteIfRevision(ctx, saltPath, data, "")
+			if err == errors.ErrorRevisionMismatch {
+				// created by another process in the meantime
+				continue
+			}
+		} else {
+			err = e.Backend.Write(ctx, saltPath, data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		e.salt = salt
+		return e.salt, nil
+	}
+}
+
+// seal encrypts data with key and the salt of the store
+func (e *Encrypted) seal(ctx context.Context, key helper.Key, data []byte) ([]byte, error) {
+	salt, err := e.storeSalt(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key.Salt = salt
+	return helper.Seal(key, data)
 }
 
 func (e *Encrypted) Exists(ctx context.Context, path string) (bool, error) {
+	if err := checkSaltPath(path); err != nil {
+		return false, err
+	}
 	return e.Backend.Exists(ctx, path)
 }
 
 func (e *Encrypted) Get(ctx context.Context, path string) ([]byte, error) {
-	// TODO: decrypt data
-	return helper.Decrypt(e.Backend.Get(ctx, path))
+	if err := checkSaltPath(path); err != nil {
+		return nil, err
+	}
+	data, err := e.Backend.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := e.open(ctx, path, data)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt %s: %w", path, err)
+	}
+	return plain, nil
+}
+
+// open decrypts the stored data of the document at path. Data in the legacy format carries no key ID, so every key of
+// the provider for it is tried, see LegacyKeyProvider.
+func (e *Encrypted) open(ctx context.Context, path string, data []byte) ([]byte, error) {
+	if !helper.IsEnvelope(data) {
+		keys, err := legacyKeys(ctx, e.Keys, path)
+		if err != nil {
+			return nil, err
+		}
+		lastErr := fmt.Errorf("%w: no key for data in the legacy format", ErrUnknownKey)
+		for _, key := range keys {
+			plain, err := helper.Open(data, func(string) (helper.Key, error) {
+				return key, nil
+			})
+			if err == nil {
+				return plain, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+	return helper.Open(data, func(id string) (helper.Key, error) {
+		return e.Keys.KeyByID(ctx, path, id)
+	})
 }
 
 func (e *Encrypted) Write(ctx context.Context, path string, data []byte) error {
-	// TODO: encrypt data
-	return e.Backend.Write(ctx, path, helper.Encrypt(data))
+	if err := checkSaltPath(path); err != nil {
+		return err
+	}
+	key, err := e.Keys.Key(ctx, path)
+	if err != nil {
+		return err
+	}
+	sealed, err := e.seal(ctx, key, data)
+	if err != nil {
+		return err
+	}
+	return e.Backend.Write(ctx, path, sealed)
 }
 
 func (e *Encrypted) Delete(ctx context.Context, path string) error {
+	if err := checkSaltPath(path); err != nil {
+		return err
+	}
 	return e.Backend.Delete(ctx, path)
 }
 
+// List lists the documents of path, without the document that holds the salt of the store
 func (e *Encrypted) List(ctx context.Context, path string) ([]string, error) {
-	return e.Backend.List(ctx, path)
+	list, err := e.Backend.List(ctx, path)
+	if err != nil || cleanPath(path) != "/" {
+		return list, err
+	}
+	filtered := make([]string, 0, len(list))
+	for _, name := range list {
+		if "/"+name != saltPath {
+			filtered = append(filtered, name)
+		}
+	}
+	return filtered, nil
 }
 
 func (e *Encrypted) GetLastModified(ctx context.Context, path string) (time.Time, error) {
+	if err := checkSaltPath(path); err != nil {
+		return time.Time{}, err
+	}
 	return e.Backend.GetLastModified(ctx, path)
 }
@@ -0,0 +1,211 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/skroczek/go-simple-json-store/errors"
+	"github.com/skroczek/go-simple-json-store/helper"
+)
+
+// RotateOptions configure Encrypted.Rotate
+type RotateOptions struct {
+	// Prefix limits the rotation to the documents below it, the default is the whole store
+	Prefix string
+	// Checkpoint is the file the progress is saved to, so that an interrupted rotation continues after the last saved
+	// document. It is removed when the rotation is complete. A checkpoint of another prefix or another active key is
+	// ignored. Without a checkpoint, every document is checked again.
+	Checkpoint string
+	// CheckpointInterval is the number of documents after which the checkpoint is saved, the default is 100
+	CheckpointInterval int
+	// Progress is called after every document
+	Progress func(RotateProgress)
+}
+
+// RotateProgress reports the state of a rotation. After a resume, the counts include the documents handled before
+// the interruption.
+type RotateProgress struct {
+	// Path is the document handled last
+	Path string `json:"path"`
+	// Checked is the number of documents checked so far
+	Checked int `json:"checked"`
+	// Rotated is the number of checked documents that were encrypted with the active key again
+	Rotated int `json:"rotated"`
+}
+
+// rotateCheckpoint is the content of the checkpoint file
+type rotateCheckpoint struct {
+	Prefix string `json:"prefix"`
+	// KeyID is the ID of the active key of Path when the checkpoint was saved. A checkpoint is discarded if the active
+	// key changed since, the documents checked before were not encrypted with the new key.
+	KeyID string `json:"keyID"`
+	RotateProgress
+}
+
+const defaultCheckpointInterval = 100
+
+// Rotate encrypts every document below opts.Prefix that is not encrypted with the active key of the KeyProvider again
+// with it. Documents are visited in the order of Walk. The wrapped backend must implement ConditionalBackend and be
+// able to walk subdirectories, otherwise ErrNotSupported is returned. Rotate can run while the store is in use: a
+// document that is written during its rotation is left alone, because it was just encrypted with the active key.
+// Rotated documents get a new modification time. A missing prefix is not an error, there is nothing to rotate.
+func (e *Encrypted) Rotate(ctx context.Context, opts RotateOptions) (RotateProgress, error) {
+	if opts.Prefix == "" {
+		opts.Prefix = "/"
+	}
+	if opts.CheckpointInterval <= 0 {
+		opts.CheckpointInterval = defaultCheckpointInterval
+	}
+	cbe, err := conditional(e.Backend)
+	if err != nil {
+		return RotateProgress{}, err
+	}
+	if _, err := e.Backend.List(ctx, opts.Prefix); os.IsNotExist(err) {
+		return RotateProgress{}, nil
+	}
+	checkpoint, err := loadCheckpoint(opts.Checkpoint, opts.Prefix)
+	if err != nil {
+		return RotateProgress{}, err
+	}
+	if checkpoint.Path != "" {
+		active, err := e.Keys.Key(ctx, checkpoint.Path)
+		if err != nil {
+			return RotateProgress{}, err
+		}
+		if active.ID != checkpoint.KeyID {
+			checkpoint = rotateCheckpoint{Prefix: opts.Prefix}
+		}
+	}
+	progress := checkpoint.RotateProgress
+	// skip is the document of the checkpoint, everything up to it was already checked
+	skip := progress.Path
+	walk := func(path string) error {
+		if path == saltPath {
+			return nil
+		}
+		if skip != "" {
+			if path == skip {
+				skip = ""
+			}
+			return nil
+		}
+		keyID, rotated, err := e.rotateDocument(ctx, cbe, path)
+		if err != nil {
+			return fmt.Errorf("unable to rotate %s: %w", path, err)
+		}
+		checkpoint.KeyID = keyID
+		progress.Path = path
+		progress.Checked++
+		if rotated {
+			progress.Rotated++
+		}
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+		if progress.Checked%opts.CheckpointInterval == 0 {
+			checkpoint.RotateProgress = progress
+			return saveCheckpoint(opts.Checkpoint, checkpoint)
+		}
+		return nil
+	}
+	err = walkTree(ctx, e.Backend, opts.Prefix, walk)
+	if err == nil && skip != "" {
+		// the document of the checkpoint was deleted in the meantime, so check everything again
+		skip = ""
+		err = walkTree(ctx, e.Backend, opts.Prefix, walk)
+	}
+	if err != nil {
+		checkpoint.RotateProgress = progress
+		if saveErr := saveCheckpoint(opts.Checkpoint, checkpoint); saveErr != nil {
+			return progress, fmt.Errorf("%w, unable to save checkpoint: %v", err, saveErr)
+		}
+		return progress, err
+	}
+	if opts.Checkpoint != "" {
+		if err := os.Remove(opts.Checkpoint); err != nil && !os.IsNotExist(err) {
+			return progress, err
+		}
+	}
+	return progress, nil
+}
+
+// rotateDocument encrypts the document at path with the active key if it is not already encrypted with it. It returns
+// the ID of the active key and whether the document was rotated.
+func (e *Encrypted) rotateDocument(ctx context.Context, cbe ConditionalBackend, path string) (string, bool, error) {
+	active, err := e.Keys.Key(ctx, path)
+	if err != nil {
+		return "", false, err
+	}
+	data, revision, err := cbe.GetWithRevision(ctx, path)
+	if os.IsNotExist(err) {
+		// deleted since it was listed
+		return active.ID, false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if helper.IsEnvelope(data) {
+		id, err := helper.EnvelopeKeyID(data)
+		if err != nil {
+			return "", false, err
+		}
+		if id == active.ID {
+			return active.ID, false, nil
+		}
+	}
+	plain, err := e.open(ctx, path, data)
+	if err != nil {
+		return "", false, err
+	}
+	sealed, err := e.seal(ctx, active, plain)
+	if err != nil {
+		return "", false, err
+	}
+	err = cbe.WriteIfRevision(ctx, path, sealed, revision)
+	if err == errors.ErrorRevisionMismatch {
+		return active.ID, false, nil
+	}
+	return active.ID, err == nil, err
+}
+
+// loadCheckpoint returns the checkpoint saved in the file name. A missing file or a checkpoint of another prefix
+// start a new rotation.
+func loadCheckpoint(name string, prefix string) (rotateCheckpoint, error) {
+	checkpoint := rotateCheckpoint{Prefix: prefix}
+	if name == "" {
+		return checkpoint, nil
+	}
+	data, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return checkpoint, err
+	}
+	var saved rotateCheckpoint
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return checkpoint, fmt.Errorf("invalid checkpoint %s: %w", name, err)
+	}
+	if saved.Prefix != prefix {
+		return checkpoint, nil
+	}
+	return saved, nil
+}
+
+// saveCheckpoint replaces the checkpoint file name, so an interruption never leaves a partial checkpoint
+func saveCheckpoint(name string, checkpoint rotateCheckpoint) error {
+	if name == "" {
+		return nil
+	}
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
@@ -0,0 +1,323 @@
+package backend_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	stderrors "errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skroczek/go-simple-json-store/backend"
+	"github.com/skroczek/go-simple-json-store/backend/fs"
+	"github.com/skroczek/go-simple-json-store/errors"
+	"github.com/skroczek/go-simple-json-store/helper"
+)
+
+// sealLegacy encrypts data the way documents were stored before the envelope format
+func sealLegacy(t *testing.T, passphrase string, data []byte) []byte {
+	hash := md5.Sum([]byte(passphrase))
+	block, err := aes.NewCipher([]byte(hex.EncodeToString(hash[:])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		t.Fatal(err)
+	}
+	return gcm.Seal(nonce, nonce, data, nil)
+}
+
+func TestEncrypted(t *testing.T) {
+	ctx := context.TODO()
+	data := []byte(`{"name":"foo"}`)
+	key := helper.Key{ID: "test", Secret: []byte("secret")}
+	lookup := func(string) (helper.Key, error) {
+		return key, nil
+	}
+	mem := fs.NewMemory()
+	e := backend.NewEncrypted(mem, backend.StaticKeyProvider(key.ID, key.Secret))
+
+	if err := e.Write(ctx, "/foo.json", data); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	stored, _ := mem.Get(ctx, "/foo.json")
+	if !helper.IsEnvelope(stored) || bytes.Contains(stored, data) {
+		t.Fatalf("stored data is not sealed: %q", stored)
+	}
+	if id, err := helper.EnvelopeKeyID(stored); err != nil || id != key.ID {
+		t.Errorf("EnvelopeKeyID() = %q, %v", id, err)
+	}
+	if got, err := e.Get(ctx, "/foo.json"); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Get() = %s, %v", got, err)
+	}
+
+	// the header is authenticated
+	tampered := bytes.Clone(stored)
+	tampered[len("GSJE")+5] ^= 1
+	if _, err := helper.Open(tampered, lookup); err == nil {
+		t.Error("Open() of a tampered header succeeded")
+	}
+	unsupported := bytes.Clone(stored)
+	unsupported[len("GSJE")]++
+	if _, err := helper.Open(unsupported, lookup); err == nil {
+		t.Error("Open() of an unknown version succeeded")
+	}
+
+	if err := mem.Write(ctx, "/legacy.json", sealLegacy(t, "secret", data)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := e.Get(ctx, "/legacy.json"); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Get() of legacy data = %s, %v", got, err)
+	}
+
+	wrong := backend.NewEncrypted(mem, backend.StaticKeyProvider(key.ID, []byte("other")))
+	if _, err := wrong.Get(ctx, "/foo.json"); err == nil {
+		t.Error("Get() with a wrong key succeeded")
+	}
+	unknown := backend.NewEncrypted(mem, backend.StaticKeyProvider("other", key.Secret))
+	if _, err := unknown.Get(ctx, "/foo.json"); !stderrors.Is(err, backend.ErrUnknownKey) {
+		t.Errorf("Get() with an unknown key ID error = %v, want %v", err, backend.ErrUnknownKey)
+	}
+	if _, err := e.Get(ctx, "/missing.json"); !stderrors.Is(err, os.ErrNotExist) {
+		t.Errorf("Get() of a missing document error = %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestEncrypted_StoreSalt(t *testing.T) {
+	ctx := context.TODO()
+	mem := fs.NewMemory()
+	keys := backend.StaticKeyProvider("test", []byte("secret"))
+	// salt returns the salt in the envelope of the stored document
+	salt := func(path string) []byte {
+		stored, _ := mem.Get(ctx, path)
+		start := len("GSJE") + 4 + len("test") + 1
+		return stored[start : start+int(stored[start-1])]
+	}
+	if err := backend.NewEncrypted(mem, keys).Write(ctx, "/foo.json", []byte(`1`)); err != nil {
+		t.Fatal(err)
+	}
+	// another process sharing the store
+	other := backend.NewEncrypted(mem, keys)
+	if err := other.Write(ctx, "/bar.json", []byte(`2`)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(salt("/foo.json"), salt("/bar.json")) {
+		t.Errorf("documents of one store are sealed with different salts")
+	}
+	if list, _ := other.List(ctx, "/"); len(list) != 2 {
+		t.Errorf("List() got = %v, the salt document must be hidden", list)
+	}
+	if err := other.Write(ctx, "/.encryption-salt.json", []byte(`{}`)); err != errors.ErrorInvalidPath {
+		t.Errorf("Write() of the salt document error = %v, want %v", err, errors.ErrorInvalidPath)
+	}
+	if _, err := other.Get(ctx, "/.encryption-salt.json"); err != errors.ErrorInvalidPath {
+		t.Errorf("Get() of the salt document error = %v, want %v", err, errors.ErrorInvalidPath)
+	}
+	if err := other.Delete(ctx, "//.encryption-salt.json"); err != errors.ErrorInvalidPath {
+		t.Errorf("Delete() of the salt document error = %v, want %v", err, errors.ErrorInvalidPath)
+	}
+}
+
+func TestKeyProvider(t *testing.T) {
+	ctx := context.TODO()
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte("from file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_KEY", "from env")
+	static := backend.StaticKeyProvider("static", []byte("static"))
+	tenants := backend.TenantKeyProvider(func(tenant string) (backend.KeyProvider, error) {
+		return backend.StaticKeyProvider(tenant, []byte("tenant "+tenant)), nil
+	})
+	tests := []struct {
+		name     string
+		provider backend.KeyProvider
+		ctx      context.Context
+		path     string
+		want     helper.Key
+		wantErr  error
+	}{
+		{"static", static, ctx, "/foo.json", helper.Key{ID: "static", Secret: []byte("static")}, nil},
+		{"env", backend.EnvKeyProvider("env", "TEST_KEY"), ctx, "/foo.json", helper.Key{ID: "env", Secret: []byte("from env")}, nil},
+		{"env unset", backend.EnvKeyProvider("env", "TEST_KEY_UNSET"), ctx, "/foo.json", helper.Key{}, backend.ErrKeyUnavailable},
+		{"file", backend.FileKeyProvider("file", keyFile), ctx, "/foo.json", helper.Key{ID: "file", Secret: []byte("from file")}, nil},
+		{"file missing", backend.FileKeyProvider("file", keyFile+".missing"), ctx, "/foo.json", helper.Key{}, backend.ErrKeyUnavailable},
+		{"path", backend.PathKeyProvider(map[string]backend.KeyProvider{"/a": static}, nil), ctx, "/a/foo.json", helper.Key{ID: "static", Secret: []byte("static")}, nil},
+		{"path segment", backend.PathKeyProvider(map[string]backend.KeyProvider{"/a": static}, nil), ctx, "/ab/foo.json", helper.Key{}, backend.ErrKeyUnavailable},
+		{"tenant", tenants, backend.WithTenant(ctx, "acme"), "/foo.json", helper.Key{ID: "acme", Secret: []byte("tenant acme")}, nil},
+		{"no tenant", tenants, ctx, "/foo.json", helper.Key{}, backend.ErrNoTenant},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.provider.Key(tt.ctx, tt.path)
+			if !stderrors.Is(err, tt.wantErr) {
+				t.Fatalf("Key() error = %v, want %v", err, tt.wantErr)
+			}
+			if got.ID != tt.want.ID || !bytes.Equal(got.Secret, tt.want.Secret) {
+				t.Errorf("Key() = %v, want %v", got, tt.want)
+			}
+			if stderrors.Is(err, os.ErrNotExist) {
+				t.Errorf("Key() error %v looks like a missing document", err)
+			}
+		})
+	}
+}
+
+func TestEncrypted_Rotate(t *testing.T) {
+	ctx := context.TODO()
+	data := []byte(`{"name":"foo"}`)
+	oldKey := backend.StaticKeyProvider("2023", []byte("old secret"))
+	newKey := backend.StaticKeyProvider("2024", []byte("new secret"))
+	mem := fs.NewMemory()
+	paths := []string{"/a.json", "/b/c.json", "/b/d.json", "/e.json", "/f.json"}
+	old := backend.NewEncrypted(mem, oldKey)
+	for _, path := range paths[1:] {
+		if err := old.Write(ctx, path, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mem.Write(ctx, paths[0], sealLegacy(t, "old secret", data)); err != nil {
+		t.Fatal(err)
+	}
+
+	e := backend.NewEncrypted(mem, backend.NewKeyRing(newKey, oldKey))
+	for _, path := range paths {
+		if got, err := e.Get(ctx, path); err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("Get(%s) before rotation = %s, %v", path, got, err)
+		}
+	}
+
+	// the first run is interrupted after two documents
+	checkpoint := filepath.Join(t.TempDir(), "rotate.json")
+	interrupted, cancel := context.WithCancel(ctx)
+	progress, err := e.Rotate(interrupted, backend.RotateOptions{
+		Checkpoint:         checkpoint,
+		CheckpointInterval: 1,
+		Progress: func(progress backend.RotateProgress) {
+			if progress.Checked == 2 {
+				cancel()
+			}
+		},
+	})
+	if err == nil || progress.Checked != 2 {
+		t.Fatalf("Rotate() = %+v, %v, want interruption after two documents", progress, err)
+	}
+	if _, err := os.Stat(checkpoint); err != nil {
+		t.Fatalf("checkpoint was not saved: %v", err)
+	}
+
+	var visited []string
+	progress, err = e.Rotate(ctx, backend.RotateOptions{
+		Checkpoint: checkpoint,
+		Progress: func(progress backend.RotateProgress) {
+			visited = append(visited, progress.Path)
+		},
+	})
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if progress.Checked != len(paths) || progress.Rotated != len(paths) {
+		t.Errorf("Rotate() = %+v, want %d checked and rotated", progress, len(paths))
+	}
+	if len(visited) != 3 || visited[0] != paths[2] {
+		t.Errorf("resumed rotation visited %v, want the last three documents", visited)
+	}
+	if _, err := os.Stat(checkpoint); !os.IsNotExist(err) {
+		t.Errorf("checkpoint was not removed: %v", err)
+	}
+
+	rotated := backend.NewEncrypted(mem, newKey)
+	for _, path := range paths {
+		if got, err := rotated.Get(ctx, path); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("Get(%s) with only the new key = %s, %v", path, got, err)
+		}
+	}
+	progress, err = e.Rotate(ctx, backend.RotateOptions{})
+	if err != nil || progress.Checked != len(paths) || progress.Rotated != 0 {
+		t.Errorf("second Rotate() = %+v, %v, want nothing rotated", progress, err)
+	}
+}
+
+func TestEncrypted_RotateWrapped(t *testing.T) {
+	ctx := context.TODO()
+	oldKey := backend.StaticKeyProvider("2023", []byte("old secret"))
+	newKey := backend.StaticKeyProvider("2024", []byte("new secret"))
+	mem := fs.NewMemory()
+	compressed := backend.NewCompressed()
+	compressed.SetBackend(mem)
+	if err := backend.NewEncrypted(compressed, oldKey).Write(ctx, "/a/b/c.json", []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	e := backend.NewEncrypted(compressed, backend.NewKeyRing(newKey, oldKey))
+	if progress, err := e.Rotate(ctx, backend.RotateOptions{}); err != nil || progress.Rotated != 1 {
+		t.Errorf("Rotate() through a proxy = %+v, %v, want the document in the subdirectory rotated", progress, err)
+	}
+	if progress, err := e.Rotate(ctx, backend.RotateOptions{Prefix: "/missing"}); err != nil || progress.Checked != 0 {
+		t.Errorf("Rotate() of a missing prefix = %+v, %v", progress, err)
+	}
+
+	// only the methods of backend.Backend
+	plain := struct{ backend.Backend }{mem}
+	hidden := backend.NewCompressed()
+	hidden.SetBackend(plain)
+	for _, be := range []backend.Backend{plain, hidden} {
+		e := backend.NewEncrypted(be, backend.NewKeyRing(newKey, oldKey))
+		if _, err := e.Rotate(ctx, backend.RotateOptions{}); !stderrors.Is(err, backend.ErrNotSupported) {
+			t.Errorf("Rotate() over %T error = %v, want %v", be, err, backend.ErrNotSupported)
+		}
+	}
+}
+
+func TestEncrypted_RotateCheckpointOfOtherKey(t *testing.T) {
+	ctx := context.TODO()
+	oldKey := backend.StaticKeyProvider("2023", []byte("old secret"))
+	mem := fs.NewMemory()
+	for _, path := range []string{"/a.json", "/b.json"} {
+		if err := backend.NewEncrypted(mem, oldKey).Write(ctx, path, []byte(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a rotation to 2023 was interrupted after /a.json, then a rotation to 2024 is started
+	checkpoint := filepath.Join(t.TempDir(), "rotate.json")
+	saved := `{"prefix":"/","keyID":"2023","path":"/a.json","checked":1,"rotated":1}`
+	if err := os.WriteFile(checkpoint, []byte(saved), 0600); err != nil {
+		t.Fatal(err)
+	}
+	e := backend.NewEncrypted(mem, backend.NewKeyRing(backend.StaticKeyProvider("2024", []byte("new secret")), oldKey))
+	progress, err := e.Rotate(ctx, backend.RotateOptions{Checkpoint: checkpoint})
+	if err != nil || progress.Checked != 2 || progress.Rotated != 2 {
+		t.Errorf("Rotate() = %+v, %v, want the checkpoint of another key discarded", progress, err)
+	}
+}
+
+func TestEncrypted_LegacyThroughSelectedProvider(t *testing.T) {
+	ctx := backend.WithTenant(context.TODO(), "acme")
+	data := []byte(`{"name":"foo"}`)
+	mem := fs.NewMemory()
+	if err := mem.Write(ctx, "/legacy.json", sealLegacy(t, "old secret", data)); err != nil {
+		t.Fatal(err)
+	}
+	ring := backend.NewKeyRing(backend.StaticKeyProvider("2024", []byte("new secret")),
+		backend.StaticKeyProvider("2023", []byte("old secret")))
+	providers := map[string]backend.KeyProvider{
+		"path": backend.PathKeyProvider(nil, ring),
+		"tenant": backend.TenantKeyProvider(func(string) (backend.KeyProvider, error) {
+			return ring, nil
+		}),
+	}
+	for name, keys := range providers {
+		if got, err := backend.NewEncrypted(mem, keys).Get(ctx, "/legacy.json"); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("Get() of legacy data with the %s provider = %s, %v", name, got, err)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package backend
+
+import (
+	"context"
+	stderrors "errors"
+	"sync"
+)
+
+// eventBuffer is the number of events a subscriber may fall behind before it is disconnected
+const eventBuffer = 256
+
+type subscriber struct {
+	prefix string
+	events chan Event
+}
+
+// EventHub distributes events to subscribers. Backends use it to implement Watcher. Publish never blocks: a subscriber
+// that falls behind by more than a buffer of events is disconnected by closing its channel, so it can tell that it
+// missed events. The zero value is ready to use, and a nil *EventHub ignores events.
+type EventHub struct {
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{}
+}
+
+// ErrWatchNotCancellable is returned by Watch for a context that is never done, the subscription would never end
+var ErrWatchNotCancellable = stderrors.New("watching requires a cancellable context")
+
+// Subscribe returns a channel that receives the events for documents below prefix until ctx is done or unsubscribe
+// is called. Callers must do one of both, otherwise the subscription is kept forever.
+func (h *EventHub) Subscribe(ctx context.Context, prefix string) (events <-chan Event, unsubscribe func()) {
+	s := &subscriber{prefix: cleanPath(prefix), events: make(chan Event, eventBuffer)}
+	h.mu.Lock()
+	if h.subscribers == nil {
+		h.subscribers = make(map[*subscriber]struct{})
+	}
+	h.subscribers[s] = struct{}{}
+	h.mu.Unlock()
+	stop := context.AfterFunc(ctx, func() {
+		h.unsubscribe(s)
+	})
+	return s.events, func() {
+		stop()
+		h.unsubscribe(s)
+	}
+}
+
+// Watch implements Watcher.Watch for backends that publish their changes to h. The subscription ends when ctx is
+// done, so ctx must be cancellable.
+func (h *EventHub) Watch(ctx context.Context, prefix string) (<-chan Event, error) {
+	if ctx.Done() == nil {
+		return nil, ErrWatchNotCancellable
+	}
+	events, _ := h.Subscribe(ctx, prefix)
+	return events, nil
+}
+
+// unsubscribe closes the channel of s, unless it was already disconnected
+func (h *EventHub) unsubscribe(s *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[s]; ok {
+		delete(h.subscribers, s)
+		close(s.events)
+	}
+}
+
+// Disconnect closes the channels of all subscribers. A publisher calls it when it lost events, so that the subscribers
+// know that they missed changes, like a subscriber that falls behind.
+func (h *EventHub) Disconnect() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subscribers {
+		delete(h.subscribers, s)
+		close(s.events)
+	}
+}
+
+// Publish sends e to all subscribers whose prefix contains the document
+func (h *EventHub) Publish(e Event) {
+	if h == nil {
+		return
+	}
+	path := cleanPath(e.Path)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subscribers {
+		if !isBelow(path, s.prefix) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			delete(h.subscribers, s)
+			close(s.events)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package backend_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/skroczek/go-simple-json-store/backend"
+)
+
+func TestEventHub_SlowSubscriber(t *testing.T) {
+	hub := backend.NewEventHub()
+	slow, _ := hub.Subscribe(context.TODO(), "/")
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	other, _ := hub.Subscribe(ctx, "/other")
+	for i := 0; i < 1000; i++ {
+		hub.Publish(backend.Event{Type: backend.EventUpdate, Path: "/foo.json"})
+	}
+	received := 0
+	for range slow {
+		received++
+	}
+	if received == 0 || received >= 1000 {
+		t.Errorf("slow subscriber received %d events before it was disconnected", received)
+	}
+	hub.Publish(backend.Event{Type: backend.EventCreate, Path: "/other/bar.json"})
+	if e := <-other; e.Path != "/other/bar.json" {
+		t.Errorf("event = %+v, want /other/bar.json", e)
+	}
+	var nilHub *backend.EventHub
+	nilHub.Publish(backend.Event{})
+}
+
+func TestEventHub_Unsubscribe(t *testing.T) {
+	hub := backend.NewEventHub()
+	events, unsubscribe := hub.Subscribe(context.Background(), "/")
+	unsubscribe()
+	hub.Publish(backend.Event{Type: backend.EventUpdate, Path: "/foo.json"})
+	if e, ok := <-events; ok {
+		t.Errorf("event %+v received after unsubscribe", e)
+	}
+	unsubscribe()
+
+	if _, err := hub.Watch(context.Background(), "/"); err != backend.ErrWatchNotCancellable {
+		t.Errorf("Watch() with background context error = %v, want %v", err, backend.ErrWatchNotCancellable)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := hub.Watch(ctx, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, ok := <-events; ok {
+		t.Errorf("Watch() channel not closed after cancel")
+	}
+}
+
+func TestEventHub_Disconnect(t *testing.T) {
+	hub := backend.NewEventHub()
+	events, unsubscribe := hub.Subscribe(context.Background(), "/")
+	hub.Disconnect()
+	if _, ok := <-events; ok {
+		t.Errorf("channel not closed by Disconnect()")
+	}
+	unsubscribe()
+	hub.Publish(backend.Event{Type: backend.EventUpdate, Path: "/foo.json"})
+}
@@ -0,0 +1,179 @@
+package backend
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/skroczek/go-simple-json-store/errors"
+	"github.com/skroczek/go-simple-json-store/helper"
+)
+
+// PassphraseEnv is the environment variable DefaultKeyProvider reads the passphrase from
+const PassphraseEnv = "GO_SIMPLE_JSON_STORE_PASSPHRASE"
+
+// ErrKeyUnavailable is returned if a KeyProvider cannot supply a key
+var ErrKeyUnavailable = stderrors.New("encryption key unavailable")
+
+// ErrUnknownKey is returned by KeyProvider.KeyByID if there is no key with the requested ID
+var ErrUnknownKey = stderrors.New("unknown encryption key")
+
+// KeyProvider supplies the keys of the Encrypted backend
+type KeyProvider interface {
+	// Key returns the key new data at path is encrypted with
+	Key(ctx context.Context, path string) (helper.Key, error)
+	// KeyByID returns the key with the given ID to decrypt the data at path. For data in the legacy format the ID is
+	// helper.LegacyKeyID.
+	KeyByID(ctx context.Context, path string, id string) (helper.Key, error)
+}
+
+// LegacyKeyProvider is a KeyProvider with more than one key for data in the legacy format, which carries no key ID.
+// Encrypted tries all of them. Providers that delegate to other providers implement it to pass the keys through.
+type LegacyKeyProvider interface {
+	KeyProvider
+	// LegacyKeys returns the keys that data at path in the legacy format may be encrypted with
+	LegacyKeys(ctx context.Context, path string) ([]helper.Key, error)
+}
+
+// legacyKeys returns the keys of provider for data at path in the legacy format
+func legacyKeys(ctx context.Context, provider KeyProvider, path string) ([]helper.Key, error) {
+	if legacy, ok := provider.(LegacyKeyProvider); ok {
+		return legacy.LegacyKeys(ctx, path)
+	}
+	key, err := provider.KeyByID(ctx, path, helper.LegacyKeyID)
+	if err != nil {
+		return nil, err
+	}
+	return []helper.Key{key}, nil
+}
+
+// singleKey is a KeyProvider with exactly one key, which is loaded on every lookup
+type singleKey struct {
+	id   string
+	load func() ([]byte, error)
+}
+
+func (s *singleKey) Key(_ context.Context, _ string) (helper.Key, error) {
+	secret, err := s.load()
+	if err != nil {
+		return helper.Key{}, err
+	}
+	if len(secret) == 0 {
+		return helper.Key{}, fmt.Errorf("%w: key %q is empty", ErrKeyUnavailable, s.id)
+	}
+	return helper.Key{ID: s.id, Secret: secret}, nil
+}
+
+func (s *singleKey) KeyByID(ctx context.Context, path string, id string) (helper.Key, error) {
+	if id != s.id && id != helper.LegacyKeyID {
+		return helper.Key{}, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	return s.Key(ctx, path)
+}
+
+// StaticKeyProvider returns a KeyProvider with a fixed key
+func StaticKeyProvider(id string, secret []byte) KeyProvider {
+	return &singleKey{id: id, load: func() ([]byte, error) {
+		return secret, nil
+	}}
+}
+
+// EnvKeyProvider returns a KeyProvider with the key in the environment variable name. The variable is read on every
+// lookup, an unset variable is reported as error.
+func EnvKeyProvider(id string, name string) KeyProvider {
+	return &singleKey{id: id, load: func() ([]byte, error) {
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("%w: environment variable %s is not set", ErrKeyUnavailable, name)
+		}
+		return []byte(secret), nil
+	}}
+}
+
+// DefaultKeyProvider returns the KeyProvider for the passphrase in the environment variable PassphraseEnv
+func DefaultKeyProvider() KeyProvider {
+	return EnvKeyProvider(helper.PassphraseKeyID, PassphraseEnv)
+}
+
+// FileKeyProvider returns a KeyProvider with the key in the file name. Trailing line breaks are ignored. The file is
+// read on every lookup, so it can be replaced while the server is running.
+func FileKeyProvider(id string, name string) KeyProvider {
+	return &singleKey{id: id, load: func() ([]byte, error) {
+		secret, err := os.ReadFile(name)
+		if err != nil {
+			// the cause is not wrapped, a missing key file must not look like a missing document
+			return nil, fmt.Errorf("%w: %v", ErrKeyUnavailable, err)
+		}
+		return []byte(strings.TrimRight(string(secret), "\r\n")), nil
+	}}
+}
+
+// selectedKey is a KeyProvider that delegates to the KeyProvider chosen for a lookup
+type selectedKey func(ctx context.Context, path string) (KeyProvider, error)
+
+func (s selectedKey) Key(ctx context.Context, path string) (helper.Key, error) {
+	provider, err := s(ctx, path)
+	if err != nil {
+		return helper.Key{}, err
+	}
+	return provider.Key(ctx, path)
+}
+
+func (s selectedKey) KeyByID(ctx context.Context, path string, id string) (helper.Key, error) {
+	provider, err := s(ctx, path)
+	if err != nil {
+		return helper.Key{}, err
+	}
+	return provider.KeyByID(ctx, path, id)
+}
+
+func (s selectedKey) LegacyKeys(ctx context.Context, path string) ([]helper.Key, error) {
+	provider, err := s(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return legacyKeys(ctx, provider, path)
+}
+
+// PathKeyProvider returns a KeyProvider that uses the provider of the longest prefix in providers that contains path.
+// Prefixes are matched on whole path segments. Paths without a matching prefix use fallback, which may be nil.
+func PathKeyProvider(providers map[string]KeyProvider, fallback KeyProvider) KeyProvider {
+	prefixes := make([]string, 0, len(providers))
+	for prefix := range providers {
+		prefixes = append(prefixes, prefix)
+	}
+	// longest prefix first
+	sort.Slice(prefixes, func(i, j int) bool {
+		return len(prefixes[i]) > len(prefixes[j])
+	})
+	return selectedKey(func(_ context.Context, path string) (KeyProvider, error) {
+		for _, prefix := range prefixes {
+			trimmed := strings.TrimSuffix(prefix, "/")
+			if path == trimmed || strings.HasPrefix(path, trimmed+"/") {
+				return providers[prefix], nil
+			}
+		}
+		if fallback == nil {
+			return nil, fmt.Errorf("%w: no key for %s", ErrKeyUnavailable, path)
+		}
+		return fallback, nil
+	})
+}
+
+// TenantKeyProvider returns a KeyProvider that uses the provider fn returns for the tenant of the request. The tenant
+// is read from the context with TenantFromContext, like TenantPrefix does.
+func TenantKeyProvider(fn func(tenant string) (KeyProvider, error)) KeyProvider {
+	return selectedKey(func(ctx context.Context, _ string) (KeyProvider, error) {
+		tenant := TenantFromContext(ctx)
+		if tenant == "" {
+			return nil, ErrNoTenant
+		}
+		if !ValidTenant(tenant) {
+			return nil, errors.ErrorInvalidPath
+		}
+		return fn(tenant)
+	})
+}
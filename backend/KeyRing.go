@@ -0,0 +1,59 @@
+package backend
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+
+	"github.com/skroczek/go-simple-json-store/helper"
+)
+
+// KeyRing is a KeyProvider with an active key for new documents and previous keys that are only used to decrypt
+// documents that were not rotated yet, see Encrypted.Rotate.
+type KeyRing struct {
+	Active   KeyProvider
+	Previous []KeyProvider
+}
+
+// NewKeyRing returns a KeyRing that encrypts with active and decrypts with active and previous
+func NewKeyRing(active KeyProvider, previous ...KeyProvider) *KeyRing {
+	return &KeyRing{Active: active, Previous: previous}
+}
+
+func (r *KeyRing) providers() []KeyProvider {
+	return append([]KeyProvider{r.Active}, r.Previous...)
+}
+
+// Key returns the key of the active provider
+func (r *KeyRing) Key(ctx context.Context, path string) (helper.Key, error) {
+	return r.Active.Key(ctx, path)
+}
+
+// KeyByID returns the key with the given ID from the first provider that knows it
+func (r *KeyRing) KeyByID(ctx context.Context, path string, id string) (helper.Key, error) {
+	for _, provider := range r.providers() {
+		key, err := provider.KeyByID(ctx, path, id)
+		if !stderrors.Is(err, ErrUnknownKey) {
+			return key, err
+		}
+	}
+	return helper.Key{}, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+}
+
+// LegacyKeys returns the keys of all providers for data in the legacy format, see LegacyKeyProvider
+func (r *KeyRing) LegacyKeys(ctx context.Context, path string) ([]helper.Key, error) {
+	var keys []helper.Key
+	var lastErr error
+	for _, provider := range r.providers() {
+		found, err := legacyKeys(ctx, provider, path)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		keys = append(keys, found...)
+	}
+	if len(keys) == 0 {
+		return nil, lastErr
+	}
+	return keys, nil
+}
@@ -0,0 +1,393 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skroczek/go-simple-json-store/errors"
+)
+
+// QuorumPolicy returns how many of the given number of backends must succeed for a write to succeed
+type QuorumPolicy func(backends int) int
+
+// QuorumAll requires every backend to succeed
+func QuorumAll(backends int) int {
+	return backends
+}
+
+// QuorumMajority requires more than half of the backends to succeed
+func QuorumMajority(backends int) int {
+	return backends/2 + 1
+}
+
+// QuorumOne requires a single backend to succeed
+func QuorumOne(backends int) int {
+	return 1
+}
+
+type MirrorOption func(*Mirror)
+
+// WithQuorum sets the quorum policy for writes and deletes, the default is QuorumAll
+func WithQuorum(policy QuorumPolicy) MirrorOption {
+	return func(m *Mirror) {
+		m.quorum = policy
+	}
+}
+
+// WithMirrorState stores the record of the last successful write or delete of every document in state, so Resync
+// knows the right version after a restart. Without it, the records are only kept in memory. The state backend must
+// not be one of the mirrored backends.
+func WithMirrorState(state Backend) MirrorOption {
+	return func(m *Mirror) {
+		m.state = state
+	}
+}
+
+// MirrorReport summarizes a Resync
+type MirrorReport struct {
+	Checked  int `json:"checked"`
+	Repaired int `json:"repaired"`
+	Failed   int `json:"failed"`
+}
+
+// mirrorRecord describes the last write or delete of a document that reached the quorum
+type mirrorRecord struct {
+	// Revision is the ContentRevision of the written document
+	Revision string `json:"revision,omitempty"`
+	Deleted  bool   `json:"deleted,omitempty"`
+}
+
+// Mirror writes every document to several backends. The first backend is the primary, reads go to it and fall back to
+// the other backends in order if it fails.
+type Mirror struct {
+	Backends []Backend
+	quorum   QuorumPolicy
+	state    Backend
+	records  sync.Map
+	locks    KeyedMutex
+}
+
+func NewMirror(backends []Backend, options ...MirrorOption) *Mirror {
+	m := &Mirror{Backends: backends, quorum: QuorumAll}
+	for _, option := range options {
+		option(m)
+	}
+	return m
+}
+
+// final reports whether an error is a valid answer of a backend rather than a failure
+func final(err error) bool {
+	return err == nil || os.IsNotExist(err) || errors.IsClientError(err)
+}
+
+// fanOut calls fn for all backends concurrently and returns their errors in the order of the backends
+func (m *Mirror) fanOut(fn func(be Backend) error) []error {
+	errs := make([]error, len(m.Backends))
+	var wg sync.WaitGroup
+	for i, be := range m.Backends {
+		wg.Add(1)
+		go func(i int, be Backend) {
+			defer wg.Done()
+			errs[i] = fn(be)
+		}(i, be)
+	}
+	wg.Wait()
+	return errs
+}
+
+// checkQuorum returns nil if enough backends succeeded. Otherwise, it returns the error of the first failed backend.
+func (m *Mirror) checkQuorum(op, path string, errs []error) error {
+	succeeded := 0
+	var first error
+	for i, err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		if first == nil {
+			first = err
+		}
+		if !errors.IsClientError(err) {
+			log.Printf("mirror: %s %s failed on backend %d: %v", op, path, i, err)
+		}
+	}
+	if succeeded >= m.quorum(len(m.Backends)) {
+		return nil
+	}
+	return first
+}
+
+// record remembers the last operation on path that reached the quorum
+func (m *Mirror) record(ctx context.Context, path string, record mirrorRecord) error {
+	if m.state == nil {
+		m.records.Store(cleanPath(path), record)
+		return nil
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return m.state.Write(ctx, path, data)
+}
+
+// lookup returns the record of path and reports whether there is one
+func (m *Mirror) lookup(ctx context.Context, path string) (mirrorRecord, bool, error) {
+	var record mirrorRecord
+	if m.state == nil {
+		value, ok := m.records.Load(cleanPath(path))
+		if ok {
+			record = value.(mirrorRecord)
+		}
+		return record, ok, nil
+	}
+	data, err := m.state.Get(ctx, path)
+	if os.IsNotExist(err) {
+		return record, false, nil
+	}
+	if err != nil {
+		return record, false, err
+	}
+	return record, true, json.Unmarshal(data, &record)
+}
+
+// Write writes the document to all backends. If the quorum is reached, the write is recorded, so Resync copies this
+// version to the backends that missed it. Otherwise, the backends that applied it are reverted to the previous version.
+// The previous version is recorded before the write, so Resync reverts the backends that could not be reverted now
+// instead of spreading the failed write.
+func (m *Mirror) Write(ctx context.Context, path string, data []byte) error {
+	defer m.locks.Lock(cleanPath(path))()
+	previous, err := m.Get(ctx, path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	existed := err == nil
+	if _, recorded, err := m.lookup(ctx, path); err != nil {
+		return err
+	} else if !recorded {
+		prior := mirrorRecord{Deleted: true}
+		if existed {
+			prior = mirrorRecord{Revision: ContentRevision(previous)}
+		}
+		if err = m.record(ctx, path, prior); err != nil {
+			return err
+		}
+	}
+	errs := m.fanOut(func(be Backend) error {
+		return be.Write(ctx, path, data)
+	})
+	if err = m.checkQuorum("write", path, errs); err != nil {
+		m.revert(ctx, path, errs, previous, existed)
+		return err
+	}
+	return m.record(ctx, path, mirrorRecord{Revision: ContentRevision(data)})
+}
+
+// revert restores the previous version of a document on the backends where a write that missed the quorum succeeded
+func (m *Mirror) revert(ctx context.Context, path string, errs []error, previous []byte, existed bool) {
+	for i, be := range m.Backends {
+		if errs[i] != nil {
+			continue
+		}
+		var err error
+		if existed {
+			err = be.Write(ctx, path, previous)
+		} else {
+			err = be.Delete(ctx, path)
+		}
+		if err != nil {
+			log.Printf("mirror: unable to revert %s on backend %d, it is reverted by the next resync: %v", path, i, err)
+		}
+	}
+}
+
+// Delete deletes the document from all backends. Backends that do not have the document count as successful, as long
+// as at least one backend had it. If the quorum is reached, the delete is recorded, so Resync deletes the document
+// from the backends that missed it.
+func (m *Mirror) Delete(ctx context.Context, path string) error {
+	defer m.locks.Lock(cleanPath(path))()
+	errs := m.fanOut(func(be Backend) error {
+		return be.Delete(ctx, path)
+	})
+	found := false
+	for _, err := range errs {
+		found = found || !os.IsNotExist(err)
+	}
+	if !found {
+		return errs[0]
+	}
+	for i, err := range errs {
+		if os.IsNotExist(err) {
+			errs[i] = nil
+		}
+	}
+	if err := m.checkQuorum("delete", path, errs); err != nil {
+		return err
+	}
+	if !strings.HasSuffix(path, ".json") {
+		// an empty directory, only documents are recorded
+		return nil
+	}
+	return m.record(ctx, path, mirrorRecord{Deleted: true})
+}
+
+// read calls fn for the primary and falls back to the other backends in order as long as they fail
+func (m *Mirror) read(fn func(be Backend) error) error {
+	var err error
+	for _, be := range m.Backends {
+		if err = fn(be); final(err) {
+			return err
+		}
+	}
+	return err
+}
+
+func (m *Mirror) Exists(ctx context.Context, path string) (exists bool, err error) {
+	err = m.read(func(be Backend) error {
+		exists, err = be.Exists(ctx, path)
+		return err
+	})
+	return exists, err
+}
+
+func (m *Mirror) Get(ctx context.Context, path string) (data []byte, err error) {
+	err = m.read(func(be Backend) error {
+		data, err = be.Get(ctx, path)
+		return err
+	})
+	return data, err
+}
+
+func (m *Mirror) List(ctx context.Context, path string) (list []string, err error) {
+	err = m.read(func(be Backend) error {
+		list, err = be.List(ctx, path)
+		return err
+	})
+	return list, err
+}
+
+func (m *Mirror) ListTypes(ctx context.Context, path string, mode fs.FileMode) (list []string, err error) {
+	err = fmt.Errorf("no mirrored backend implements backend.FileBackend")
+	for _, be := range m.Backends {
+		fbe, ok := be.(FileBackend)
+		if !ok {
+			continue
+		}
+		if list, err = fbe.ListTypes(ctx, path, mode); final(err) {
+			return list, err
+		}
+	}
+	return list, err
+}
+
+func (m *Mirror) GetLastModified(ctx context.Context, path string) (modTime time.Time, err error) {
+	err = m.read(func(be Backend) error {
+		modTime, err = be.GetLastModified(ctx, path)
+		return err
+	})
+	return modTime, err
+}
+
+// Resync repairs backends that diverged. Every document found on any backend is brought to the state of its last write
+// or delete that reached the quorum: a deleted document is removed, otherwise the recorded version is copied to the
+// backends where it is missing or different. Writes that did not reach the quorum are reverted. Documents without a
+// record, e.g. written before the mirror was set up or without WithMirrorState after a restart, get the most recently
+// modified version.
+func (m *Mirror) Resync(ctx context.Context) (MirrorReport, error) {
+	var report MirrorReport
+	paths := make(map[string]bool)
+	var order []string
+	for i, be := range m.Backends {
+		err := Walk(ctx, be, "/", func(path string) error {
+			if !paths[path] {
+				paths[path] = true
+				order = append(order, path)
+			}
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return report, fmt.Errorf("unable to walk backend %d: %w", i, err)
+		}
+	}
+	for _, path := range order {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		report.Checked++
+		repaired, err := m.resyncDocument(ctx, path)
+		report.Repaired += repaired
+		if err != nil {
+			report.Failed++
+			log.Printf("mirror: unable to resync %s: %v", path, err)
+		}
+	}
+	return report, nil
+}
+
+func (m *Mirror) resyncDocument(ctx context.Context, path string) (int, error) {
+	defer m.locks.Lock(cleanPath(path))()
+	record, recorded, err := m.lookup(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+	contents := make([][]byte, len(m.Backends))
+	var source []byte
+	var newestTime time.Time
+	found := false
+	for i, be := range m.Backends {
+		data, err := be.Get(ctx, path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return 0, err
+		}
+		contents[i] = data
+		if recorded && !record.Deleted {
+			if !found && ContentRevision(data) == record.Revision {
+				source, found = data, true
+			}
+			continue
+		}
+		modTime, err := be.GetLastModified(ctx, path)
+		if err != nil {
+			return 0, err
+		}
+		if !found || modTime.After(newestTime) {
+			source, newestTime, found = data, modTime, true
+		}
+	}
+	repaired := 0
+	if recorded && record.Deleted {
+		for i, be := range m.Backends {
+			if contents[i] == nil {
+				continue
+			}
+			if err := be.Delete(ctx, path); err != nil && !os.IsNotExist(err) {
+				return repaired, err
+			}
+			repaired++
+		}
+		return repaired, nil
+	}
+	if !found {
+		return 0, fmt.Errorf("no backend has the recorded revision %s", record.Revision)
+	}
+	for i, be := range m.Backends {
+		if contents[i] != nil && bytes.Equal(contents[i], source) {
+			continue
+		}
+		if err := be.Write(ctx, path, source); err != nil {
+			return repaired, err
+		}
+		repaired++
+	}
+	return repaired, nil
+}
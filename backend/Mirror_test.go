@@ -0,0 +1,158 @@
+package backend_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skroczek/go-simple-json-store/backend"
+	"github.com/skroczek/go-simple-json-store/backend/fs"
+)
+
+// failingBackend fails every call while failing is set
+type failingBackend struct {
+	backend.Backend
+	failing bool
+}
+
+var errUnavailable = fmt.Errorf("unavailable")
+
+func (f *failingBackend) Get(ctx context.Context, path string) ([]byte, error) {
+	if f.failing {
+		return nil, errUnavailable
+	}
+	return f.Backend.Get(ctx, path)
+}
+
+func (f *failingBackend) Write(ctx context.Context, path string, data []byte) error {
+	if f.failing {
+		return errUnavailable
+	}
+	return f.Backend.Write(ctx, path, data)
+}
+
+func (f *failingBackend) Delete(ctx context.Context, path string) error {
+	if f.failing {
+		return errUnavailable
+	}
+	return f.Backend.Delete(ctx, path)
+}
+
+func TestMirror(t *testing.T) {
+	ctx := context.TODO()
+	primary := &failingBackend{Backend: fs.NewMemory()}
+	replica := fs.NewMemory()
+	broken := &failingBackend{Backend: fs.NewMemory(), failing: true}
+	m := backend.NewMirror([]backend.Backend{primary, replica, broken}, backend.WithQuorum(backend.QuorumMajority))
+
+	if err := m.Write(ctx, "/bar.json", []byte("1")); err != nil {
+		t.Fatalf("Write() with majority error = %v", err)
+	}
+	primary.failing = true
+	if data, err := m.Get(ctx, "/bar.json"); err != nil || string(data) != "1" {
+		t.Errorf("Get() with failing primary got = %s, %v", data, err)
+	}
+	if err := m.Write(ctx, "/bar.json", []byte("2")); err == nil {
+		t.Errorf("Write() without majority succeeded")
+	}
+	if data, _ := replica.Get(ctx, "/bar.json"); string(data) != "1" {
+		t.Errorf("replica after failed Write() got = %s, want the reverted version 1", data)
+	}
+
+	primary.failing = false
+	broken.failing = false
+	report, err := m.Resync(ctx)
+	if err != nil {
+		t.Fatalf("Resync() error = %v", err)
+	}
+	// only the broken backend misses the first write, the replica was reverted by the failed write
+	if report.Checked != 1 || report.Repaired != 1 {
+		t.Errorf("Resync() got = %+v", report)
+	}
+	// the second write did not reach the quorum, so the first one is the valid version
+	for i, be := range m.Backends {
+		if data, err := be.Get(ctx, "/bar.json"); err != nil || string(data) != "1" {
+			t.Errorf("backend %d after Resync() got = %s, %v, want 1", i, data, err)
+		}
+	}
+}
+
+func TestMirror_ResyncDelete(t *testing.T) {
+	ctx := context.TODO()
+	broken := &failingBackend{Backend: fs.NewMemory()}
+	state := fs.NewMemory()
+	backends := []backend.Backend{fs.NewMemory(), fs.NewMemory(), broken}
+	m := backend.NewMirror(backends, backend.WithQuorum(backend.QuorumMajority), backend.WithMirrorState(state))
+	if err := m.Write(ctx, "/bar.json", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	broken.failing = true
+	if err := m.Delete(ctx, "/bar.json"); err != nil {
+		t.Fatalf("Delete() with majority error = %v", err)
+	}
+	broken.failing = false
+
+	// a new mirror on the same state, like after a restart
+	m = backend.NewMirror(backends, backend.WithQuorum(backend.QuorumMajority), backend.WithMirrorState(state))
+	report, err := m.Resync(ctx)
+	if err != nil {
+		t.Fatalf("Resync() error = %v", err)
+	}
+	if report.Checked != 1 || report.Repaired != 1 {
+		t.Errorf("Resync() got = %+v", report)
+	}
+	for i, be := range m.Backends {
+		if _, err := be.Get(ctx, "/bar.json"); !os.IsNotExist(err) {
+			t.Errorf("backend %d after Resync() error = %v, want the document to stay deleted", i, err)
+		}
+	}
+}
+
+func TestMirror_FailedWriteOfNewDocument(t *testing.T) {
+	ctx := context.TODO()
+	primary := fs.NewMemory()
+	broken := &failingBackend{Backend: fs.NewMemory(), failing: true}
+	for _, state := range []backend.Backend{nil, fs.NewMemory()} {
+		options := []backend.MirrorOption{}
+		if state != nil {
+			options = append(options, backend.WithMirrorState(state))
+		}
+		m := backend.NewMirror([]backend.Backend{primary, broken}, options...)
+		if err := m.Write(ctx, "/new.json", []byte("1")); err == nil {
+			t.Fatalf("Write() without quorum succeeded")
+		}
+		if ok, _ := primary.Exists(ctx, "/new.json"); ok {
+			t.Errorf("failed Write() was not reverted")
+		}
+		// the backend that applied the write cannot be reverted now, the next resync must remove the document
+		broken.failing = false
+		_ = broken.Backend.Write(ctx, "/new.json", []byte("1"))
+		if _, err := m.Resync(ctx); err != nil {
+			t.Fatalf("Resync() error = %v", err)
+		}
+		for i, be := range m.Backends {
+			if ok, _ := be.Exists(ctx, "/new.json"); ok {
+				t.Errorf("backend %d after Resync() has the document of the failed write", i)
+			}
+		}
+		broken.failing = true
+	}
+}
+
+func TestMirror_DeleteDirectoryWithState(t *testing.T) {
+	ctx := context.TODO()
+	var backends []backend.Backend
+	for i := 0; i < 2; i++ {
+		root := t.TempDir()
+		if err := os.Mkdir(filepath.Join(root, "dir"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		backends = append(backends, fs.NewFilesystemBackend(root, fs.WithDeleteEmptyDirs()))
+	}
+	m := backend.NewMirror(backends, backend.WithMirrorState(fs.NewMemory()))
+	if err := m.Delete(ctx, "/dir"); err != nil {
+		t.Errorf("Delete() of an empty directory error = %v", err)
+	}
+}
@@ -0,0 +1,197 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skroczek/go-simple-json-store/errors"
+)
+
+// whiteoutPrefix starts the name of the marker that hides a document of the lower layer
+const whiteoutPrefix = ".wh."
+
+// Overlay layers a writable upper backend over a read-only lower backend. Reads fall through to the lower backend,
+// writes go to the upper one. Deleting a document of the lower backend stores a whiteout marker next to it in the
+// upper backend, so the upper backend must create directories on write. The lower backend is never modified.
+//
+// Only documents are hidden by whiteouts, a directory of the lower backend whose documents are all deleted is still
+// listed.
+type Overlay struct {
+	Upper Backend
+	Lower Backend
+}
+
+func NewOverlay(upper, lower Backend) *Overlay {
+	return &Overlay{Upper: upper, Lower: lower}
+}
+
+// checkOverlayPath rejects paths that refer to whiteout markers
+func checkOverlayPath(p string) error {
+	for _, segment := range strings.Split(p, "/") {
+		if strings.HasPrefix(segment, whiteoutPrefix) {
+			return errors.ErrorInvalidPath
+		}
+	}
+	return nil
+}
+
+func whiteoutPath(p string) string {
+	dir, name := path.Split(cleanPath(p))
+	return dir + whiteoutPrefix + name
+}
+
+func (o *Overlay) whitedOut(ctx context.Context, p string) (bool, error) {
+	return o.Upper.Exists(ctx, whiteoutPath(p))
+}
+
+func (o *Overlay) Exists(ctx context.Context, path string) (bool, error) {
+	if err := checkOverlayPath(path); err != nil {
+		return false, err
+	}
+	exists, err := o.Upper.Exists(ctx, path)
+	if err != nil || exists {
+		return exists, err
+	}
+	if hidden, err := o.whitedOut(ctx, path); err != nil || hidden {
+		return false, err
+	}
+	return o.Lower.Exists(ctx, path)
+}
+
+// read calls fn for the upper backend and falls back to the lower backend if the document is neither in the upper
+// backend nor hidden by a whiteout
+func (o *Overlay) read(ctx context.Context, path string, fn func(be Backend) error) error {
+	if err := checkOverlayPath(path); err != nil {
+		return err
+	}
+	err := fn(o.Upper)
+	if !os.IsNotExist(err) {
+		return err
+	}
+	hidden, werr := o.whitedOut(ctx, path)
+	if werr != nil {
+		return werr
+	}
+	if hidden {
+		return err
+	}
+	return fn(o.Lower)
+}
+
+func (o *Overlay) Get(ctx context.Context, path string) (data []byte, err error) {
+	err = o.read(ctx, path, func(be Backend) error {
+		data, err = be.Get(ctx, path)
+		return err
+	})
+	return data, err
+}
+
+func (o *Overlay) GetLastModified(ctx context.Context, path string) (modTime time.Time, err error) {
+	err = o.read(ctx, path, func(be Backend) error {
+		modTime, err = be.GetLastModified(ctx, path)
+		return err
+	})
+	return modTime, err
+}
+
+// Write writes the document to the upper backend and removes a whiteout that hid it
+func (o *Overlay) Write(ctx context.Context, path string, data []byte) error {
+	if err := checkOverlayPath(path); err != nil {
+		return err
+	}
+	if err := o.Upper.Write(ctx, path, data); err != nil {
+		return err
+	}
+	if err := o.Upper.Delete(ctx, whiteoutPath(path)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Delete deletes a document from the upper backend and hides it in the lower backend with a whiteout. Directories
+// are only deleted in the upper backend.
+func (o *Overlay) Delete(ctx context.Context, path string) error {
+	if err := checkOverlayPath(path); err != nil {
+		return err
+	}
+	if !strings.HasSuffix(path, ".json") {
+		return o.Upper.Delete(ctx, path)
+	}
+	upperErr := o.Upper.Delete(ctx, path)
+	if upperErr != nil && !os.IsNotExist(upperErr) {
+		return upperErr
+	}
+	hidden, err := o.whitedOut(ctx, path)
+	if err != nil {
+		return err
+	}
+	inLower := false
+	if !hidden {
+		if inLower, err = o.Lower.Exists(ctx, path); err != nil {
+			return err
+		}
+	}
+	if inLower {
+		return o.Upper.Write(ctx, whiteoutPath(path), []byte("{}"))
+	}
+	return upperErr
+}
+
+// merge returns the sorted union of the names the upper and the lower backend return for a directory. Whiteout
+// markers are removed, and so are the names of the lower backend they hide.
+func (o *Overlay) merge(dir string, fn func(be Backend) ([]string, error)) ([]string, error) {
+	if err := checkOverlayPath(dir); err != nil {
+		return nil, err
+	}
+	upper, upperErr := fn(o.Upper)
+	if upperErr != nil && !os.IsNotExist(upperErr) {
+		return nil, upperErr
+	}
+	lower, lowerErr := fn(o.Lower)
+	if lowerErr != nil && !os.IsNotExist(lowerErr) {
+		return nil, lowerErr
+	}
+	if upperErr != nil && lowerErr != nil {
+		return nil, upperErr
+	}
+	seen := make(map[string]bool)
+	list := make([]string, 0, len(upper)+len(lower))
+	for _, name := range upper {
+		if strings.HasPrefix(name, whiteoutPrefix) {
+			seen[strings.TrimPrefix(name, whiteoutPrefix)] = true
+			continue
+		}
+		seen[name] = true
+		list = append(list, name)
+	}
+	for _, name := range lower {
+		if !seen[name] {
+			seen[name] = true
+			list = append(list, name)
+		}
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+func (o *Overlay) List(ctx context.Context, path string) ([]string, error) {
+	return o.merge(path, func(be Backend) ([]string, error) {
+		return be.List(ctx, path)
+	})
+}
+
+func (o *Overlay) ListTypes(ctx context.Context, path string, mode fs.FileMode) ([]string, error) {
+	return o.merge(path, func(be Backend) ([]string, error) {
+		fbe, ok := be.(FileBackend)
+		if !ok {
+			return nil, fmt.Errorf("overlay layer does not implement backend.FileBackend")
+		}
+		return fbe.ListTypes(ctx, path, mode)
+	})
+}
@@ -0,0 +1,76 @@
+package backend_test
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/skroczek/go-simple-json-store/backend"
+	"github.com/skroczek/go-simple-json-store/backend/backendtest"
+	"github.com/skroczek/go-simple-json-store/backend/fs"
+	"github.com/skroczek/go-simple-json-store/errors"
+)
+
+func TestOverlay_Conformance(t *testing.T) {
+	backendtest.RunConformance(t, func(t *testing.T) backend.Backend {
+		return backend.NewOverlay(fs.NewFilesystemBackend(t.TempDir(), fs.WithCreateDirs()), fs.NewMemory())
+	})
+}
+
+func TestOverlay(t *testing.T) {
+	ctx := context.TODO()
+	lower := fs.NewMemory()
+	_ = lower.Write(ctx, "/seed/a.json", []byte(`"a"`))
+	_ = lower.Write(ctx, "/seed/b.json", []byte(`"b"`))
+	upper := fs.NewFilesystemBackend(t.TempDir(), fs.WithCreateDirs())
+	o := backend.NewOverlay(upper, lower)
+
+	if data, err := o.Get(ctx, "/seed/a.json"); err != nil || string(data) != `"a"` {
+		t.Errorf("Get() from lower = %s, %v", data, err)
+	}
+	if err := o.Write(ctx, "/seed/a.json", []byte(`"a2"`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Write(ctx, "/seed/c.json", []byte(`"c"`)); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := o.Get(ctx, "/seed/a.json"); string(data) != `"a2"` {
+		t.Errorf("Get() after Write() = %s, want \"a2\"", data)
+	}
+	if err := o.Delete(ctx, "/seed/b.json"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.Get(ctx, "/seed/b.json"); !os.IsNotExist(err) {
+		t.Errorf("Get() after Delete() error = %v, want not exist", err)
+	}
+	if ok, _ := o.Exists(ctx, "/seed/b.json"); ok {
+		t.Errorf("Exists() after Delete() = true")
+	}
+	if err := o.Delete(ctx, "/seed/b.json"); !os.IsNotExist(err) {
+		t.Errorf("second Delete() error = %v, want not exist", err)
+	}
+	if list, _ := o.List(ctx, "/seed"); !reflect.DeepEqual(list, []string{"a.json", "c.json"}) {
+		t.Errorf("List() = %v, want [a.json c.json]", list)
+	}
+
+	for path, want := range map[string]string{"/seed/a.json": `"a"`, "/seed/b.json": `"b"`} {
+		if data, _ := lower.Get(ctx, path); string(data) != want {
+			t.Errorf("lower %s = %s, want %s", path, data, want)
+		}
+	}
+
+	if err := o.Write(ctx, "/seed/b.json", []byte(`"b2"`)); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := o.Get(ctx, "/seed/b.json"); string(data) != `"b2"` {
+		t.Errorf("Get() after writing a deleted document = %s, want \"b2\"", data)
+	}
+	if list, _ := o.List(ctx, "/seed"); !reflect.DeepEqual(list, []string{"a.json", "b.json", "c.json"}) {
+		t.Errorf("List() = %v, want [a.json b.json c.json]", list)
+	}
+
+	if err := o.Write(ctx, "/seed/.wh.a.json", []byte("{}")); err != errors.ErrorInvalidPath {
+		t.Errorf("Write() of whiteout error = %v, want %v", err, errors.ErrorInvalidPath)
+	}
+}
@@ -0,0 +1,251 @@
+package backend
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/skroczek/go-simple-json-store/errors"
+)
+
+// Quota is a proxy that limits the total size and number of documents below path prefixes. Writes over a limit fail
+// with errors.ErrorQuotaExceeded, or errors.ErrorDocumentTooLarge if the document alone exceeds the limit. The usage
+// counters are built from the wrapped backend in the background when it is set, and kept current by the writes and
+// deletes through the proxy. Writes below a prefix wait until the counters are built. Sizes are taken from Stat if the
+// wrapped backend implements Stater. Subdirectories are only counted if it implements FileBackend.
+type Quota struct {
+	Backend Backend
+	usage   []QuotaUsage
+
+	mu     sync.Mutex
+	loaded bool
+	// sizes holds the size of every document below one of the prefixes
+	sizes map[string]int64
+}
+
+func NewQuota(limits ...QuotaLimit) *Quota {
+	q := &Quota{}
+	for _, limit := range limits {
+		limit.Prefix = cleanPath(limit.Prefix)
+		q.usage = append(q.usage, QuotaUsage{QuotaLimit: limit})
+	}
+	return q
+}
+
+func (q *Quota) SetBackend(backend Backend) {
+	q.mu.Lock()
+	q.Backend = backend
+	q.loaded = false
+	q.mu.Unlock()
+	if len(q.usage) == 0 {
+		return
+	}
+	go func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if err := q.load(context.Background()); err != nil {
+			// the next write below a prefix tries again
+			log.Printf("unable to load quota usage: %v", err)
+		}
+	}()
+}
+
+// documentSize returns the size of the document at path. It uses Stat if be implements Stater, so the document is not
+// loaded into memory.
+func documentSize(ctx context.Context, be Backend, path string) (int64, error) {
+	if stater, ok := be.(Stater); ok {
+		info, err := stater.Stat(ctx, path)
+		return info.Size, err
+	}
+	data, err := be.Get(ctx, path)
+	return int64(len(data)), err
+}
+
+// load rebuilds the usage counters from the wrapped backend. It must be called with the lock held.
+func (q *Quota) load(ctx context.Context) error {
+	if q.loaded {
+		return nil
+	}
+	sizes := make(map[string]int64)
+	for _, usage := range q.usage {
+		err := Walk(ctx, q.Backend, usage.Prefix, func(path string) error {
+			if _, ok := sizes[path]; ok {
+				return nil
+			}
+			size, err := documentSize(ctx, q.Backend, path)
+			if os.IsNotExist(err) {
+				// deleted since it was listed
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			sizes[path] = size
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	for i := range q.usage {
+		q.usage[i].Bytes, q.usage[i].Documents = 0, 0
+	}
+	q.sizes = sizes
+	for path, size := range sizes {
+		q.account(path, size, 1)
+	}
+	q.loaded = true
+	return nil
+}
+
+// account adds the size and count deltas of a document to all limits it falls under
+func (q *Quota) account(path string, bytes, documents int64) {
+	for i := range q.usage {
+		if isBelow(path, q.usage[i].Prefix) {
+			q.usage[i].Bytes += bytes
+			q.usage[i].Documents += documents
+		}
+	}
+}
+
+func (q *Quota) limited(path string) bool {
+	for _, usage := range q.usage {
+		if isBelow(path, usage.Prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *Quota) check(path string, size int64) error {
+	previous, exists := q.sizes[path]
+	for _, usage := range q.usage {
+		if !isBelow(path, usage.Prefix) {
+			continue
+		}
+		if usage.MaxBytes > 0 && size > usage.MaxBytes {
+			return errors.ErrorDocumentTooLarge
+		}
+		if usage.MaxBytes > 0 && usage.Bytes-previous+size > usage.MaxBytes {
+			return errors.ErrorQuotaExceeded
+		}
+		if usage.MaxDocuments > 0 && !exists && usage.Documents+1 > usage.MaxDocuments {
+			return errors.ErrorQuotaExceeded
+		}
+	}
+	return nil
+}
+
+// Usage returns the usage of all limits that apply to path or to a prefix below it
+func (q *Quota) Usage(ctx context.Context, path string) ([]QuotaUsage, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.load(ctx); err != nil {
+		return nil, err
+	}
+	path = cleanPath(path)
+	list := make([]QuotaUsage, 0)
+	for _, usage := range q.usage {
+		if isBelow(path, usage.Prefix) || isBelow(usage.Prefix, path) {
+			list = append(list, usage)
+		}
+	}
+	return list, nil
+}
+
+func (q *Quota) Exists(ctx context.Context, path string) (bool, error) {
+	return q.Backend.Exists(ctx, path)
+}
+
+func (q *Quota) Get(ctx context.Context, path string) ([]byte, error) {
+	return q.Backend.Get(ctx, path)
+}
+
+// Write checks the limits and writes the document. Writes below a prefix are serialized, so concurrent writes cannot
+// exceed a limit together.
+func (q *Quota) Write(ctx context.Context, path string, data []byte) error {
+	return q.write(ctx, path, data, func() error {
+		return q.Backend.Write(ctx, path, data)
+	})
+}
+
+func (q *Quota) GetWithRevision(ctx context.Context, path string) ([]byte, string, error) {
+	cbe, err := conditional(q.Backend)
+	if err != nil {
+		return nil, "", err
+	}
+	return cbe.GetWithRevision(ctx, path)
+}
+
+// WriteIfRevision checks the limits and writes the document if its revision matches, see ConditionalBackend
+func (q *Quota) WriteIfRevision(ctx context.Context, path string, data []byte, revision string) error {
+	cbe, err := conditional(q.Backend)
+	if err != nil {
+		return err
+	}
+	return q.write(ctx, path, data, func() error {
+		return cbe.WriteIfRevision(ctx, path, data, revision)
+	})
+}
+
+// write checks the limits, calls fn to write data to path and accounts for it
+func (q *Quota) write(ctx context.Context, path string, data []byte, fn func() error) error {
+	clean := cleanPath(path)
+	if !q.limited(clean) {
+		return fn()
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.load(ctx); err != nil {
+		return err
+	}
+	size := int64(len(data))
+	if err := q.check(clean, size); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	previous, exists := q.sizes[clean]
+	if exists {
+		q.account(clean, size-previous, 0)
+	} else {
+		q.account(clean, size, 1)
+	}
+	q.sizes[clean] = size
+	return nil
+}
+
+func (q *Quota) Walk(ctx context.Context, prefix string, fn WalkFunc) error {
+	return walkTree(ctx, q.Backend, prefix, fn)
+}
+
+func (q *Quota) Delete(ctx context.Context, path string) error {
+	clean := cleanPath(path)
+	if !q.limited(clean) {
+		return q.Backend.Delete(ctx, path)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.load(ctx); err != nil {
+		return err
+	}
+	if err := q.Backend.Delete(ctx, path); err != nil {
+		return err
+	}
+	if size, ok := q.sizes[clean]; ok {
+		q.account(clean, -size, -1)
+		delete(q.sizes, clean)
+	}
+	return nil
+}
+
+func (q *Quota) List(ctx context.Context, path string) ([]string, error) {
+	return q.Backend.List(ctx, path)
+}
+
+func (q *Quota) GetLastModified(ctx context.Context, path string) (time.Time, error) {
+	return q.Backend.GetLastModified(ctx, path)
+}
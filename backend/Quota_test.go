@@ -0,0 +1,84 @@
+package backend_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/skroczek/go-simple-json-store/backend"
+	"github.com/skroczek/go-simple-json-store/backend/fs"
+	"github.com/skroczek/go-simple-json-store/errors"
+)
+
+func TestQuota(t *testing.T) {
+	ctx := context.TODO()
+	be := fs.NewFilesystemBackend(t.TempDir(), fs.WithCreateDirs())
+	_ = be.Write(ctx, "/tenants/acme/existing.json", []byte("12345"))
+	q := backend.NewQuota(backend.QuotaLimit{Prefix: "/tenants/acme/", MaxBytes: 20, MaxDocuments: 3})
+	q.SetBackend(be)
+
+	tests := []struct {
+		path string
+		data string
+		want error
+	}{
+		{"/tenants/acme/a.json", "12345", nil},
+		{"/tenants/acme/b.json", "123456789012345678901", errors.ErrorDocumentTooLarge},
+		{"/tenants/acme/b.json", "1234567890123", errors.ErrorQuotaExceeded},
+		{"/tenants/acme/a.json", "1234567890", nil},
+		{"/tenants/acme/b.json", "1", nil},
+		{"/tenants/acme/c.json", "1", errors.ErrorQuotaExceeded},
+		{"/tenants/other/c.json", "123456789012345678901", nil},
+	}
+	for _, tt := range tests {
+		if err := q.Write(ctx, tt.path, []byte(tt.data)); err != tt.want {
+			t.Errorf("Write(%s, %d bytes) error = %v, want %v", tt.path, len(tt.data), err, tt.want)
+		}
+	}
+	usage, err := q.Usage(ctx, "/tenants")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 1 || usage[0].Bytes != 16 || usage[0].Documents != 3 {
+		t.Errorf("Usage() got = %+v", usage)
+	}
+	if err := q.Delete(ctx, "/tenants/acme/a.json"); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Write(ctx, "/tenants/acme/c.json", []byte("1")); err != nil {
+		t.Errorf("Write() after Delete() error = %v", err)
+	}
+	if usage, _ = q.Usage(ctx, "/tenants/acme/c.json"); usage[0].Bytes != 7 || usage[0].Documents != 3 {
+		t.Errorf("Usage() after Delete() got = %+v", usage)
+	}
+}
+
+// countingMemory counts the documents read with Get
+type countingMemory struct {
+	*fs.Memory
+	gets atomic.Int32
+}
+
+func (c *countingMemory) Get(ctx context.Context, path string) ([]byte, error) {
+	c.gets.Add(1)
+	return c.Memory.Get(ctx, path)
+}
+
+func TestQuota_LoadWithStat(t *testing.T) {
+	ctx := context.TODO()
+	be := &countingMemory{Memory: fs.NewMemory()}
+	_ = be.Write(ctx, "/tenants/acme/a.json", []byte("12345"))
+	_ = be.Write(ctx, "/tenants/acme/b/c.json", []byte("123"))
+	q := backend.NewQuota(backend.QuotaLimit{Prefix: "/tenants/acme/", MaxBytes: 20})
+	q.SetBackend(be)
+	usage, err := q.Usage(ctx, "/tenants/acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 1 || usage[0].Bytes != 8 || usage[0].Documents != 2 {
+		t.Errorf("Usage() got = %+v, want 8 bytes in 2 documents", usage)
+	}
+	if gets := be.gets.Load(); gets != 0 {
+		t.Errorf("loading the usage read %d documents, want sizes from Stat", gets)
+	}
+}
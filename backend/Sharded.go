@@ -0,0 +1,159 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sharded spreads documents across several backends by hashing their path. Listings merge the results of all shards.
+type Sharded struct {
+	Shards []Backend
+}
+
+// NewSharded returns a backend that spreads the documents across shards. At least one shard is required.
+func NewSharded(shards ...Backend) (*Sharded, error) {
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("sharded backend needs at least one shard")
+	}
+	return &Sharded{Shards: shards}, nil
+}
+
+// Shard returns the backend responsible for the document at path
+func (s *Sharded) Shard(path string) Backend {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(cleanPath(path)))
+	return s.Shards[h.Sum32()%uint32(len(s.Shards))]
+}
+
+func (s *Sharded) Exists(ctx context.Context, path string) (bool, error) {
+	return s.Shard(path).Exists(ctx, path)
+}
+
+func (s *Sharded) Get(ctx context.Context, path string) ([]byte, error) {
+	return s.Shard(path).Get(ctx, path)
+}
+
+func (s *Sharded) Write(ctx context.Context, path string, data []byte) error {
+	return s.Shard(path).Write(ctx, path, data)
+}
+
+// Delete deletes a document from its shard. Directories exist on every shard, so they are deleted on all of them.
+func (s *Sharded) Delete(ctx context.Context, path string) error {
+	if strings.HasSuffix(path, ".json") {
+		return s.Shard(path).Delete(ctx, path)
+	}
+	var result error = os.ErrNotExist
+	for _, shard := range s.Shards {
+		err := shard.Delete(ctx, path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		result = nil
+	}
+	return result
+}
+
+// merge calls fn for all shards and returns the sorted union of the results. Shards that do not know the path are
+// skipped, if none knows it, os.ErrNotExist is returned.
+func (s *Sharded) merge(fn func(shard Backend) ([]string, error)) ([]string, error) {
+	seen := make(map[string]bool)
+	list := make([]string, 0)
+	found := false
+	for _, shard := range s.Shards {
+		names, err := fn(shard)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		found = true
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				list = append(list, name)
+			}
+		}
+	}
+	if !found {
+		return nil, os.ErrNotExist
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+func (s *Sharded) List(ctx context.Context, path string) ([]string, error) {
+	return s.merge(func(shard Backend) ([]string, error) {
+		return shard.List(ctx, path)
+	})
+}
+
+func (s *Sharded) ListTypes(ctx context.Context, path string, mode fs.FileMode) ([]string, error) {
+	return s.merge(func(shard Backend) ([]string, error) {
+		fbe, ok := shard.(FileBackend)
+		if !ok {
+			return nil, fmt.Errorf("shard does not implement backend.FileBackend")
+		}
+		return fbe.ListTypes(ctx, path, mode)
+	})
+}
+
+func (s *Sharded) GetLastModified(ctx context.Context, path string) (time.Time, error) {
+	return s.Shard(path).GetLastModified(ctx, path)
+}
+
+// Rebalance moves the documents stored on the previous shards to the shard they belong to now. It is used after the
+// shard count changed, previous are the shards before the change and may overlap with the current ones. Rebalance can
+// be run again after an interruption: if a document exists on both shards, the newer version is kept. Subdirectories
+// are only visited on shards that implement FileBackend.
+func (s *Sharded) Rebalance(ctx context.Context, previous ...Backend) (int, error) {
+	moved := 0
+	for _, source := range previous {
+		err := Walk(ctx, source, "/", func(path string) error {
+			target := s.Shard(path)
+			if target == source {
+				return nil
+			}
+			if err := move(ctx, source, target, path); err != nil {
+				return fmt.Errorf("unable to move %s: %w", path, err)
+			}
+			moved++
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return moved, err
+		}
+	}
+	return moved, nil
+}
+
+func move(ctx context.Context, source, target Backend, path string) error {
+	data, err := source.Get(ctx, path)
+	if err != nil {
+		return err
+	}
+	sourceTime, err := source.GetLastModified(ctx, path)
+	if err != nil {
+		return err
+	}
+	targetTime, err := target.GetLastModified(ctx, path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// the document was written to the target after an interrupted rebalance, the source version is outdated
+	if err != nil || sourceTime.After(targetTime) {
+		if err := target.Write(ctx, path, data); err != nil {
+			return err
+		}
+	}
+	return source.Delete(ctx, path)
+}
@@ -0,0 +1,58 @@
+package backend_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/skroczek/go-simple-json-store/backend"
+	"github.com/skroczek/go-simple-json-store/backend/fs"
+)
+
+func TestSharded(t *testing.T) {
+	ctx := context.TODO()
+	a, b, c := fs.NewMemory(), fs.NewMemory(), fs.NewMemory()
+	sharded, err := backend.NewSharded(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := sharded.Write(ctx, fmt.Sprintf("/%02d.json", i), []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	listA, _ := a.List(ctx, "/")
+	listB, _ := b.List(ctx, "/")
+	if len(listA) == 0 || len(listB) == 0 {
+		t.Errorf("documents not spread across shards: %d, %d", len(listA), len(listB))
+	}
+	list, err := sharded.List(ctx, "/")
+	if err != nil || len(list) != 20 || list[0] != "00.json" {
+		t.Errorf("List() got = %v, %v", list, err)
+	}
+
+	resharded, _ := backend.NewSharded(a, b, c)
+	moved, err := resharded.Rebalance(ctx, a, b)
+	if err != nil {
+		t.Fatalf("Rebalance() error = %v", err)
+	}
+	listC, _ := c.List(ctx, "/")
+	if moved == 0 || len(listC) == 0 {
+		t.Errorf("Rebalance() moved %d documents, %d to the new shard", moved, len(listC))
+	}
+	for i := 0; i < 20; i++ {
+		path := fmt.Sprintf("/%02d.json", i)
+		if data, err := resharded.Shard(path).Get(ctx, path); err != nil || data[0] != byte(i) {
+			t.Errorf("Get(%s) after Rebalance() got = %v, %v", path, data, err)
+		}
+	}
+	if list, _ = resharded.List(ctx, "/"); len(list) != 20 {
+		t.Errorf("List() after Rebalance() got %d documents, want 20", len(list))
+	}
+}
+
+func TestNewSharded_NoShards(t *testing.T) {
+	if _, err := backend.NewSharded(); err == nil {
+		t.Errorf("NewSharded() without shards succeeded")
+	}
+}
@@ -0,0 +1,80 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+var ErrTransactionClosed = fmt.Errorf("transaction already committed or rolled back")
+
+// TransactionOp is a staged write or delete
+type TransactionOp struct {
+	Path   string `json:"path"`
+	Data   []byte `json:"data,omitempty"`
+	Delete bool   `json:"delete,omitempty"`
+}
+
+// CommitFunc applies the operations of a transaction atomically
+type CommitFunc func(ctx context.Context, ops []TransactionOp) error
+
+// StagedTransaction collects the operations of a transaction in memory and hands them to a CommitFunc. Backends use it
+// to implement Transactional. A later operation on a path replaces an earlier one.
+type StagedTransaction struct {
+	ops      []TransactionOp
+	validate func(path string) error
+	commit   CommitFunc
+	closed   bool
+}
+
+// NewStagedTransaction returns a transaction that checks every staged path with validate and applies the operations
+// with commit
+func NewStagedTransaction(validate func(path string) error, commit CommitFunc) *StagedTransaction {
+	return &StagedTransaction{validate: validate, commit: commit}
+}
+
+func (t *StagedTransaction) stage(op TransactionOp) error {
+	if t.closed {
+		return ErrTransactionClosed
+	}
+	if err := t.validate(op.Path); err != nil {
+		return err
+	}
+	op.Path = "/" + strings.Trim(op.Path, "/")
+	for i := range t.ops {
+		if t.ops[i].Path == op.Path {
+			t.ops[i] = op
+			return nil
+		}
+	}
+	t.ops = append(t.ops, op)
+	return nil
+}
+
+func (t *StagedTransaction) Write(path string, data []byte) error {
+	return t.stage(TransactionOp{Path: path, Data: data})
+}
+
+func (t *StagedTransaction) Delete(path string) error {
+	return t.stage(TransactionOp{Path: path, Delete: true})
+}
+
+func (t *StagedTransaction) Commit(ctx context.Context) error {
+	if t.closed {
+		return ErrTransactionClosed
+	}
+	t.closed = true
+	if len(t.ops) == 0 {
+		return nil
+	}
+	return t.commit(ctx, t.ops)
+}
+
+func (t *StagedTransaction) Rollback() error {
+	if t.closed {
+		return ErrTransactionClosed
+	}
+	t.closed = true
+	t.ops = nil
+	return nil
+}
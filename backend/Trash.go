@@ -0,0 +1,273 @@
+package backend
+
+import (
+	"context"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skroczek/go-simple-json-store/errors"
+)
+
+// trashDocumentsPath is the directory in the trash backend the deleted documents are moved to. The modification time of
+// a trashed document is the time it was deleted.
+const trashDocumentsPath = "/documents"
+
+type TrashOption func(*Trash)
+
+// WithTrashRetention purges documents that have been in the trash longer than retention
+func WithTrashRetention(retention time.Duration) TrashOption {
+	return func(t *Trash) {
+		t.retention = retention
+	}
+}
+
+// WithTrashPurgeInterval sets how often expired documents are purged in the background. It has no effect without
+// WithTrashRetention.
+func WithTrashPurgeInterval(interval time.Duration) TrashOption {
+	return func(t *Trash) {
+		t.purgeInterval = interval
+	}
+}
+
+// Trash is a proxy that moves deleted documents into a separate trash backend, from where they can be restored or
+// purged. A FilesystemBackend used as trash backend needs fs.WithCreateDirs. Writes and deletes of the same document
+// through the proxy are serialized, so a delete never moves an outdated version into the trash.
+type Trash struct {
+	Backend       Backend
+	Trash         Backend
+	retention     time.Duration
+	purgeInterval time.Duration
+	locks         KeyedMutex
+	stop          chan struct{}
+	done          chan struct{}
+}
+
+func NewTrash(trash Backend, options ...TrashOption) *Trash {
+	t := &Trash{Trash: trash, purgeInterval: time.Hour}
+	for _, option := range options {
+		option(t)
+	}
+	if t.retention > 0 && t.purgeInterval > 0 {
+		t.stop = make(chan struct{})
+		t.done = make(chan struct{})
+		go t.purgeLoop()
+	}
+	return t
+}
+
+func (t *Trash) SetBackend(backend Backend) {
+	t.Backend = backend
+}
+
+func trashPath(path string) string {
+	return trashDocumentsPath + "/" + strings.Trim(path, "/")
+}
+
+func (t *Trash) expired(deleted time.Time) bool {
+	return t.retention > 0 && time.Since(deleted) > t.retention
+}
+
+func (t *Trash) Exists(ctx context.Context, path string) (bool, error) {
+	return t.Backend.Exists(ctx, path)
+}
+
+func (t *Trash) Get(ctx context.Context, path string) ([]byte, error) {
+	return t.Backend.Get(ctx, path)
+}
+
+func (t *Trash) Write(ctx context.Context, path string, data []byte) error {
+	defer t.locks.Lock(cleanPath(path))()
+	return t.Backend.Write(ctx, path, data)
+}
+
+// Delete moves the document into the trash. A document deleted earlier under the same path is replaced.
+func (t *Trash) Delete(ctx context.Context, path string) error {
+	defer t.locks.Lock(cleanPath(path))()
+	data, err := t.Backend.Get(ctx, path)
+	if err != nil {
+		if os.IsNotExist(err) || errors.IsClientError(err) {
+			// not a document, e.g. a directory, the wrapped backend decides what to do
+			return t.Backend.Delete(ctx, path)
+		}
+		return err
+	}
+	path = cleanPath(path)
+	previous, err := t.Trash.Get(ctx, trashPath(path))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err = t.Trash.Write(ctx, trashPath(path), data); err != nil {
+		return err
+	}
+	if err = t.Backend.Delete(ctx, path); err != nil {
+		if previous != nil {
+			_ = t.Trash.Write(ctx, trashPath(path), previous)
+		} else {
+			_ = t.Trash.Delete(ctx, trashPath(path))
+		}
+		return err
+	}
+	return nil
+}
+
+func (t *Trash) List(ctx context.Context, path string) ([]string, error) {
+	return t.Backend.List(ctx, path)
+}
+
+func (t *Trash) GetLastModified(ctx context.Context, path string) (time.Time, error) {
+	return t.Backend.GetLastModified(ctx, path)
+}
+
+// trashed calls fn for every document in the trash below path with the time it was deleted
+func (t *Trash) trashed(ctx context.Context, path string, fn func(path string, deleted time.Time) error) error {
+	err := Walk(ctx, t.Trash, trashPath(path), func(p string) error {
+		deleted, err := t.Trash.GetLastModified(ctx, p)
+		if os.IsNotExist(err) {
+			// restored or purged in the meantime
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return fn(strings.TrimPrefix(p, trashDocumentsPath), deleted)
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Trashed lists the documents in the trash below path, ordered by path
+func (t *Trash) Trashed(ctx context.Context, path string) ([]TrashedDocument, error) {
+	list := make([]TrashedDocument, 0)
+	err := t.trashed(ctx, path, func(p string, deleted time.Time) error {
+		if !t.expired(deleted) {
+			list = append(list, TrashedDocument{Path: p, Deleted: deleted})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return list, nil
+}
+
+// RestoreTrashed moves the document back from the trash. It fails with errors.ErrorAlreadyExists if a document has
+// been created under the same path in the meantime.
+func (t *Trash) RestoreTrashed(ctx context.Context, path string) error {
+	path = cleanPath(path)
+	if err := checkDocumentPath(path); err != nil {
+		return err
+	}
+	defer t.locks.Lock(path)()
+	deleted, err := t.Trash.GetLastModified(ctx, trashPath(path))
+	if err != nil {
+		return err
+	}
+	if t.expired(deleted) {
+		return os.ErrNotExist
+	}
+	exists, err := t.Backend.Exists(ctx, path)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return errors.ErrorAlreadyExists
+	}
+	data, err := t.Trash.Get(ctx, trashPath(path))
+	if err != nil {
+		return err
+	}
+	if err = t.Backend.Write(ctx, path, data); err != nil {
+		return err
+	}
+	return t.Trash.Delete(ctx, trashPath(path))
+}
+
+// Purge removes the trashed document at path for good. If path is a directory, all trashed documents below it are
+// purged.
+func (t *Trash) Purge(ctx context.Context, path string) error {
+	if err := checkPath(path); err != nil {
+		return err
+	}
+	return t.purge(ctx, path, func(deleted time.Time) bool {
+		return true
+	})
+}
+
+// PurgeExpired removes all documents that have been in the trash longer than the retention period
+func (t *Trash) PurgeExpired(ctx context.Context) error {
+	return t.purge(ctx, "/", t.expired)
+}
+
+func (t *Trash) purge(ctx context.Context, path string, match func(deleted time.Time) bool) error {
+	var purge []string
+	if checkDocumentPath(path) == nil {
+		purge = append(purge, cleanPath(path))
+	} else {
+		err := t.trashed(ctx, path, func(p string, deleted time.Time) error {
+			if match(deleted) {
+				purge = append(purge, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, p := range purge {
+		if err := t.purgeDocument(ctx, p, match); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeDocument removes a document from the trash if it still matches, it may have been replaced by a newer delete
+func (t *Trash) purgeDocument(ctx context.Context, path string, match func(deleted time.Time) bool) error {
+	defer t.locks.Lock(path)()
+	deleted, err := t.Trash.GetLastModified(ctx, trashPath(path))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !match(deleted) {
+		return nil
+	}
+	if err := t.Trash.Delete(ctx, trashPath(path)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (t *Trash) purgeLoop() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.purgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := t.PurgeExpired(context.Background()); err != nil {
+				log.Printf("unable to purge trash: %v", err)
+			}
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// Close stops the background purging
+func (t *Trash) Close() error {
+	if t.stop != nil {
+		close(t.stop)
+		<-t.done
+		t.stop = nil
+	}
+	return nil
+}
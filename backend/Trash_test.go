@@ -0,0 +1,79 @@
+package backend_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/skroczek/go-simple-json-store/backend"
+	"github.com/skroczek/go-simple-json-store/backend/fs"
+	"github.com/skroczek/go-simple-json-store/errors"
+)
+
+func TestTrash(t *testing.T) {
+	ctx := context.TODO()
+	trash := backend.NewTrash(fs.NewMemory())
+	trash.SetBackend(fs.NewMemory())
+	for _, path := range []string{"/foo/bar.json", "/foo/baz.json", "/qux.json"} {
+		_ = trash.Write(ctx, path, []byte(path))
+		if err := trash.Delete(ctx, path); err != nil {
+			t.Fatalf("Delete(%s) error = %v", path, err)
+		}
+		if _, err := trash.Get(ctx, path); !os.IsNotExist(err) {
+			t.Errorf("Get(%s) after Delete() error = %v", path, err)
+		}
+	}
+	list, err := trash.Trashed(ctx, "/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Path != "/foo/bar.json" || list[1].Path != "/foo/baz.json" {
+		t.Errorf("Trashed(/foo) got = %v", list)
+	}
+
+	if err := trash.RestoreTrashed(ctx, "/foo/bar.json"); err != nil {
+		t.Fatalf("RestoreTrashed() error = %v", err)
+	}
+	if data, _ := trash.Get(ctx, "/foo/bar.json"); string(data) != "/foo/bar.json" {
+		t.Errorf("Get() after RestoreTrashed() got = %s", data)
+	}
+	_ = trash.Delete(ctx, "/foo/bar.json")
+	_ = trash.Write(ctx, "/foo/bar.json", []byte("new"))
+	if err := trash.RestoreTrashed(ctx, "/foo/bar.json"); err != errors.ErrorAlreadyExists {
+		t.Errorf("RestoreTrashed() over existing document error = %v", err)
+	}
+
+	if err := trash.Purge(ctx, "/foo"); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ = trash.Trashed(ctx, "/"); len(list) != 1 || list[0].Path != "/qux.json" {
+		t.Errorf("Trashed() after Purge() got = %v", list)
+	}
+	if err := trash.RestoreTrashed(ctx, "/foo/baz.json"); !os.IsNotExist(err) {
+		t.Errorf("RestoreTrashed() of purged document error = %v", err)
+	}
+	if err := trash.Purge(ctx, "/qux.json"); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ = trash.Trashed(ctx, "/"); len(list) != 0 {
+		t.Errorf("Trashed() after Purge() of a document got = %v", list)
+	}
+}
+
+func TestTrash_DeleteAgain(t *testing.T) {
+	ctx := context.TODO()
+	trash := backend.NewTrash(fs.NewMemory())
+	trash.SetBackend(fs.NewMemory())
+	for _, content := range []string{"1", "2"} {
+		_ = trash.Write(ctx, "/foo.json", []byte(content))
+		if err := trash.Delete(ctx, "/foo.json"); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+	}
+	if err := trash.RestoreTrashed(ctx, "/foo.json"); err != nil {
+		t.Fatalf("RestoreTrashed() error = %v", err)
+	}
+	if data, _ := trash.Get(ctx, "/foo.json"); string(data) != "2" {
+		t.Errorf("Get() after RestoreTrashed() got = %s, want the last deleted version", data)
+	}
+}
@@ -0,0 +1,280 @@
+package backend
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skroczek/go-simple-json-store/errors"
+)
+
+type VersionedOption func(*Versioned)
+
+// WithMaxRevisions limits the number of revisions kept per document. The oldest revisions are removed first.
+func WithMaxRevisions(max int) VersionedOption {
+	return func(v *Versioned) {
+		v.maxRevisions = max
+	}
+}
+
+// WithMaxRevisionAge removes revisions that were archived longer ago than maxAge
+func WithMaxRevisionAge(maxAge time.Duration) VersionedOption {
+	return func(v *Versioned) {
+		v.maxAge = maxAge
+	}
+}
+
+// Versioned is a proxy that archives the current content of a document in the history backend before it is
+// overwritten or deleted. Revision n of /foo/bar.json is stored as /foo/bar.json/n.json in the history backend, so a
+// FilesystemBackend used as history backend needs fs.WithCreateDirs.
+type Versioned struct {
+	Backend      Backend
+	History      Backend
+	maxRevisions int
+	maxAge       time.Duration
+	mu           sync.Mutex
+}
+
+func NewVersioned(history Backend, options ...VersionedOption) *Versioned {
+	v := &Versioned{History: history}
+	for _, option := range options {
+		option(v)
+	}
+	return v
+}
+
+func (v *Versioned) SetBackend(backend Backend) {
+	v.Backend = backend
+}
+
+func revisionPath(path string, revision int) string {
+	return "/" + strings.Trim(path, "/") + "/" + strconv.Itoa(revision) + ".json"
+}
+
+// archive stores the current content of path as a new revision and returns its number, or 0 if there is no current
+// content. It must be called with the lock held.
+func (v *Versioned) archive(ctx context.Context, path string) (int, error) {
+	data, err := v.Backend.Get(ctx, path)
+	if err != nil {
+		if os.IsNotExist(err) || errors.IsClientError(err) {
+			// nothing to archive, the wrapped backend reports client errors on its own
+			return 0, nil
+		}
+		return 0, err
+	}
+	revisions, err := v.revisions(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+	next := 1
+	if len(revisions) > 0 {
+		next = revisions[len(revisions)-1].Revision + 1
+	}
+	if err = v.History.Write(ctx, revisionPath(path, next), data); err != nil {
+		return 0, err
+	}
+	return next, nil
+}
+
+// change archives the current content of path and applies fn. If fn fails, the revision is removed again, so the
+// history only contains content that was actually replaced.
+func (v *Versioned) change(ctx context.Context, path string, fn func() error) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	revision, err := v.archive(ctx, path)
+	if err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		if revision > 0 {
+			_ = v.History.Delete(ctx, revisionPath(path, revision))
+		}
+		return err
+	}
+	return v.prune(ctx, path)
+}
+
+// expired reports whether a revision is older than the maximum age
+func (v *Versioned) expired(revision Revision) bool {
+	return v.maxAge > 0 && time.Since(revision.Archived) > v.maxAge
+}
+
+// prune removes the revisions of path that exceed the limits. It must be called with the lock held.
+func (v *Versioned) prune(ctx context.Context, path string) error {
+	if v.maxRevisions <= 0 && v.maxAge <= 0 {
+		return nil
+	}
+	revisions, err := v.revisions(ctx, path)
+	if err != nil {
+		return err
+	}
+	for i, revision := range revisions {
+		tooMany := v.maxRevisions > 0 && len(revisions)-i > v.maxRevisions
+		if !tooMany && !v.expired(revision) {
+			continue
+		}
+		if err := v.History.Delete(ctx, revisionPath(path, revision.Revision)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Revisions returns the archived revisions of path, the oldest first. Revisions older than the maximum age are removed
+// first.
+func (v *Versioned) Revisions(ctx context.Context, path string) ([]Revision, error) {
+	if err := checkDocumentPath(path); err != nil {
+		return nil, err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err := v.prune(ctx, path); err != nil {
+		return nil, err
+	}
+	return v.revisions(ctx, path)
+}
+
+func (v *Versioned) revisions(ctx context.Context, path string) ([]Revision, error) {
+	names, err := v.History.List(ctx, "/"+strings.Trim(path, "/"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Revision{}, nil
+		}
+		return nil, err
+	}
+	revisions := make([]Revision, 0, len(names))
+	for _, name := range names {
+		revision, err := strconv.Atoi(strings.TrimSuffix(name, filepath.Ext(name)))
+		if err != nil {
+			continue
+		}
+		archived, err := v.History.GetLastModified(ctx, revisionPath(path, revision))
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, Revision{Revision: revision, Archived: archived})
+	}
+	sort.Slice(revisions, func(i, j int) bool { return revisions[i].Revision < revisions[j].Revision })
+	return revisions, nil
+}
+
+// GetRevision returns the content of a revision. A revision older than the maximum age is removed and reported as not
+// existing.
+func (v *Versioned) GetRevision(ctx context.Context, path string, revision int) ([]byte, error) {
+	if err := checkDocumentPath(path); err != nil {
+		return nil, err
+	}
+	if revision < 1 {
+		return nil, os.ErrNotExist
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	archived, err := v.History.GetLastModified(ctx, revisionPath(path, revision))
+	if err != nil {
+		return nil, err
+	}
+	if v.expired(Revision{Revision: revision, Archived: archived}) {
+		if err := v.History.Delete(ctx, revisionPath(path, revision)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return nil, os.ErrNotExist
+	}
+	return v.History.Get(ctx, revisionPath(path, revision))
+}
+
+// PruneExpired removes all revisions older than the maximum age, including those of documents that are no longer
+// written or read. It can be called periodically to keep the history small.
+func (v *Versioned) PruneExpired(ctx context.Context) error {
+	if v.maxAge <= 0 {
+		return nil
+	}
+	var expired []string
+	err := Walk(ctx, v.History, "/", func(path string) error {
+		archived, err := v.History.GetLastModified(ctx, path)
+		if err != nil {
+			return err
+		}
+		if v.expired(Revision{Archived: archived}) {
+			expired = append(expired, path)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, path := range expired {
+		if err := v.History.Delete(ctx, path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreRevision makes the given revision the current content of path. The content it replaces is archived as a new
+// revision.
+func (v *Versioned) RestoreRevision(ctx context.Context, path string, revision int) error {
+	data, err := v.GetRevision(ctx, path, revision)
+	if err != nil {
+		return err
+	}
+	return v.Write(ctx, path, data)
+}
+
+func (v *Versioned) Exists(ctx context.Context, path string) (bool, error) {
+	return v.Backend.Exists(ctx, path)
+}
+
+func (v *Versioned) Get(ctx context.Context, path string) ([]byte, error) {
+	return v.Backend.Get(ctx, path)
+}
+
+func (v *Versioned) Write(ctx context.Context, path string, data []byte) error {
+	return v.change(ctx, path, func() error {
+		return v.Backend.Write(ctx, path, data)
+	})
+}
+
+func (v *Versioned) GetWithRevision(ctx context.Context, path string) ([]byte, string, error) {
+	cbe, err := conditional(v.Backend)
+	if err != nil {
+		return nil, "", err
+	}
+	return cbe.GetWithRevision(ctx, path)
+}
+
+// WriteIfRevision archives the current content and writes the document if its revision matches. If it does not
+// match, the archived revision is removed again.
+func (v *Versioned) WriteIfRevision(ctx context.Context, path string, data []byte, revision string) error {
+	cbe, err := conditional(v.Backend)
+	if err != nil {
+		return err
+	}
+	return v.change(ctx, path, func() error {
+		return cbe.WriteIfRevision(ctx, path, data, revision)
+	})
+}
+
+func (v *Versioned) Walk(ctx context.Context, prefix string, fn WalkFunc) error {
+	return walkTree(ctx, v.Backend, prefix, fn)
+}
+
+func (v *Versioned) Delete(ctx context.Context, path string) error {
+	return v.change(ctx, path, func() error {
+		return v.Backend.Delete(ctx, path)
+	})
+}
+
+func (v *Versioned) List(ctx context.Context, path string) ([]string, error) {
+	return v.Backend.List(ctx, path)
+}
+
+func (v *Versioned) GetLastModified(ctx context.Context, path string) (time.Time, error) {
+	return v.Backend.GetLastModified(ctx, path)
+}
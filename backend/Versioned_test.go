@@ -0,0 +1,95 @@
+package backend_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/skroczek/go-simple-json-store/backend"
+	"github.com/skroczek/go-simple-json-store/backend/fs"
+	"github.com/skroczek/go-simple-json-store/errors"
+)
+
+func TestVersioned(t *testing.T) {
+	ctx := context.TODO()
+	v := backend.NewVersioned(fs.NewMemory(), backend.WithMaxRevisions(3))
+	v.SetBackend(fs.NewMemory())
+	for _, content := range []string{"1", "2", "3", "4"} {
+		if err := v.Write(ctx, "/foo/bar.json", []byte(content)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := v.Delete(ctx, "/foo/bar.json"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := v.Get(ctx, "/foo/bar.json"); !os.IsNotExist(err) {
+		t.Errorf("Get() after Delete() error = %v", err)
+	}
+	revisions, err := v.Revisions(ctx, "/foo/bar.json")
+	if err != nil {
+		t.Fatalf("Revisions() error = %v", err)
+	}
+	var got []int
+	for _, revision := range revisions {
+		got = append(got, revision.Revision)
+	}
+	if len(got) != 3 || got[0] != 2 || got[2] != 4 {
+		t.Fatalf("Revisions() got = %v, want [2 3 4]", got)
+	}
+	data, err := v.GetRevision(ctx, "/foo/bar.json", 4)
+	if err != nil || string(data) != "4" {
+		t.Errorf("GetRevision(4) got = %s, %v, want 4", data, err)
+	}
+	if err := v.RestoreRevision(ctx, "/foo/bar.json", 3); err != nil {
+		t.Fatalf("RestoreRevision() error = %v", err)
+	}
+	if data, _ := v.Get(ctx, "/foo/bar.json"); string(data) != "3" {
+		t.Errorf("Get() after RestoreRevision() got = %s, want 3", data)
+	}
+	if _, err := v.GetRevision(ctx, "/foo/bar.json", 1); !os.IsNotExist(err) {
+		t.Errorf("GetRevision(1) error = %v, want pruned", err)
+	}
+}
+
+func TestVersioned_FailedWrite(t *testing.T) {
+	ctx := context.TODO()
+	history := fs.NewMemory()
+	quota := backend.NewQuota(backend.QuotaLimit{Prefix: "/", MaxBytes: 10})
+	quota.SetBackend(fs.NewMemory())
+	v := backend.NewVersioned(history)
+	v.SetBackend(quota)
+	if err := v.Write(ctx, "/foo.json", []byte("1")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := v.Write(ctx, "/foo.json", []byte("too large for the quota")); err == nil {
+		t.Fatal("Write() beyond the quota succeeded")
+	}
+	if revisions, _ := v.Revisions(ctx, "/foo.json"); len(revisions) != 0 {
+		t.Errorf("Revisions() after failed Write() = %v, want none", revisions)
+	}
+}
+
+func TestVersioned_MaxRevisionAge(t *testing.T) {
+	ctx := context.TODO()
+	history := fs.NewMemory()
+	v := backend.NewVersioned(history, backend.WithMaxRevisionAge(50*time.Millisecond))
+	v.SetBackend(fs.NewMemory())
+	for _, path := range []string{"/foo.json", "/bar.json"} {
+		_ = v.Write(ctx, path, []byte("1"))
+		_ = v.Write(ctx, path, []byte("2"))
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := v.GetRevision(ctx, "/foo.json", 1); !os.IsNotExist(err) {
+		t.Errorf("GetRevision() of expired revision error = %v, want not exist", err)
+	}
+	if err := v.PruneExpired(ctx); err != nil {
+		t.Fatalf("PruneExpired() error = %v", err)
+	}
+	if ok, _ := history.Exists(ctx, "/bar.json/1.json"); ok {
+		t.Error("PruneExpired() kept an expired revision")
+	}
+	if _, err := v.GetRevision(ctx, "/../foo.json", 1); err != errors.ErrorInvalidPath {
+		t.Errorf("GetRevision() of invalid path error = %v, want %v", err, errors.ErrorInvalidPath)
+	}
+}
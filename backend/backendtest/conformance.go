@@ -0,0 +1,343 @@
+// Package backendtest provides a conformance test suite for backend.Backend implementations
+package backendtest
+
+import (
+	"context"
+	stderrors "errors"
+	"io/fs"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/skroczek/go-simple-json-store/backend"
+	"github.com/skroczek/go-simple-json-store/errors"
+)
+
+// Factory returns a new, empty backend. Every test case calls it once. Backends that need directories to be created
+// on write, like fs.FilesystemBackend with fs.WithCreateDirs, must be configured accordingly.
+type Factory func(t *testing.T) backend.Backend
+
+// RunConformance checks that the backends returned by factory fulfill the contract of backend.Backend. If the backend
+// also implements backend.FileBackend, ListTypes is checked as well. backend.Walk is checked for backends that
+// implement backend.Walker or backend.FileBackend.
+func RunConformance(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, be backend.Backend)
+	}{
+		{"InvalidPath", testInvalidPath},
+		{"NotExist", testNotExist},
+		{"WriteGet", testWriteGet},
+		{"Exists", testExists},
+		{"List", testList},
+		{"GetLastModified", testGetLastModified},
+		{"Delete", testDelete},
+		{"DeleteDirectory", testDeleteDirectory},
+		{"ListTypes", testListTypes},
+		{"Walk", testWalk},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, factory(t))
+		})
+	}
+}
+
+func write(t *testing.T, be backend.Backend, documents map[string]string) {
+	t.Helper()
+	for path, content := range documents {
+		if err := be.Write(context.TODO(), path, []byte(content)); err != nil {
+			t.Fatalf("Write(%s) error = %v", path, err)
+		}
+	}
+}
+
+func sorted(list []string) []string {
+	if list == nil {
+		list = []string{}
+	}
+	sort.Strings(list)
+	return list
+}
+
+func testInvalidPath(t *testing.T, be backend.Backend) {
+	ctx := context.TODO()
+	tests := map[string]error{
+		"/.json":   errors.ErrorInvalidPath,
+		".json":    errors.ErrorInvalidPath,
+		"/foobar":  errors.ErrorMissingExtension,
+		"/foo.txt": errors.ErrorMissingExtension,
+		// paths must not leave the root of the backend
+		"/../foo.json":      errors.ErrorInvalidPath,
+		"/foo/../../x.json": errors.ErrorInvalidPath,
+	}
+	for path, want := range tests {
+		if _, err := be.Exists(ctx, path); !stderrors.Is(err, want) {
+			t.Errorf("Exists(%s) error = %v, want %v", path, err, want)
+		}
+		if _, err := be.Get(ctx, path); !stderrors.Is(err, want) {
+			t.Errorf("Get(%s) error = %v, want %v", path, err, want)
+		}
+		if err := be.Write(ctx, path, []byte("{}")); !stderrors.Is(err, want) {
+			t.Errorf("Write(%s) error = %v, want %v", path, err, want)
+		}
+		if _, err := be.GetLastModified(ctx, path); !stderrors.Is(err, want) {
+			t.Errorf("GetLastModified(%s) error = %v, want %v", path, err, want)
+		}
+		if !errors.IsClientError(want) {
+			t.Errorf("IsClientError(%v) = false", want)
+		}
+	}
+	for _, path := range []string{"/../foo.json", "/foo/../../x.json", "/..", "", "/"} {
+		if err := be.Delete(ctx, path); !stderrors.Is(err, errors.ErrorInvalidPath) {
+			t.Errorf("Delete(%s) error = %v, want %v", path, err, errors.ErrorInvalidPath)
+		}
+	}
+	for _, path := range []string{"/..", "/../", "/foo/../.."} {
+		if _, err := be.List(ctx, path); !stderrors.Is(err, errors.ErrorInvalidPath) {
+			t.Errorf("List(%s) error = %v, want %v", path, err, errors.ErrorInvalidPath)
+		}
+		if fbe, ok := be.(backend.FileBackend); ok {
+			if _, err := fbe.ListTypes(ctx, path, fs.ModeDir); !stderrors.Is(err, errors.ErrorInvalidPath) {
+				t.Errorf("ListTypes(%s) error = %v, want %v", path, err, errors.ErrorInvalidPath)
+			}
+		}
+	}
+}
+
+func testNotExist(t *testing.T, be backend.Backend) {
+	ctx := context.TODO()
+	write(t, be, map[string]string{"/foo/bar.json": "{}"})
+	for _, path := range []string{"/missing.json", "/foo/missing.json", "/missing/bar.json"} {
+		if _, err := be.Get(ctx, path); !stderrors.Is(err, os.ErrNotExist) {
+			t.Errorf("Get(%s) error = %v, want %v", path, err, os.ErrNotExist)
+		}
+		if _, err := be.GetLastModified(ctx, path); !stderrors.Is(err, os.ErrNotExist) {
+			t.Errorf("GetLastModified(%s) error = %v, want %v", path, err, os.ErrNotExist)
+		}
+		if err := be.Delete(ctx, path); !stderrors.Is(err, os.ErrNotExist) {
+			t.Errorf("Delete(%s) error = %v, want %v", path, err, os.ErrNotExist)
+		}
+	}
+	if _, err := be.List(ctx, "/missing"); !stderrors.Is(err, os.ErrNotExist) {
+		t.Errorf("List(/missing) error = %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func testWriteGet(t *testing.T, be backend.Backend) {
+	ctx := context.TODO()
+	documents := map[string]string{
+		"/foo.json":         `{"name":"foo"}`,
+		"/foo/bar.json":     `{"name":"bar"}`,
+		"/foo/bar/baz.json": `["baz"]`,
+	}
+	write(t, be, documents)
+	write(t, be, map[string]string{"/foo.json": `{"name":"foo2"}`})
+	documents["/foo.json"] = `{"name":"foo2"}`
+	for path, want := range documents {
+		got, err := be.Get(ctx, path)
+		if err != nil {
+			t.Errorf("Get(%s) error = %v", path, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("Get(%s) got = %s, want %s", path, got, want)
+		}
+	}
+}
+
+func testExists(t *testing.T, be backend.Backend) {
+	ctx := context.TODO()
+	write(t, be, map[string]string{"/foo/bar.json": "{}"})
+	tests := map[string]bool{
+		"/foo/bar.json": true,
+		"/foo/baz.json": false,
+		"/bar/baz.json": false,
+	}
+	for path, want := range tests {
+		got, err := be.Exists(ctx, path)
+		if err != nil {
+			t.Errorf("Exists(%s) error = %v", path, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("Exists(%s) got = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func testList(t *testing.T, be backend.Backend) {
+	ctx := context.TODO()
+	write(t, be, map[string]string{
+		"/root.json":        "{}",
+		"/foo/bar.json":     "{}",
+		"/foo/baz.json":     "{}",
+		"/foo/qux/bar.json": "{}",
+	})
+	tests := map[string][]string{
+		"/":        {"root.json"},
+		"/foo":     {"bar.json", "baz.json"},
+		"/foo/":    {"bar.json", "baz.json"},
+		"/foo/qux": {"bar.json"},
+	}
+	for path, want := range tests {
+		got, err := be.List(ctx, path)
+		if err != nil {
+			t.Errorf("List(%s) error = %v", path, err)
+			continue
+		}
+		if got = sorted(got); !reflect.DeepEqual(got, want) {
+			t.Errorf("List(%s) got = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func testGetLastModified(t *testing.T, be backend.Backend) {
+	ctx := context.TODO()
+	write(t, be, map[string]string{"/foo.json": "{}"})
+	first, err := be.GetLastModified(ctx, "/foo.json")
+	if err != nil {
+		t.Fatalf("GetLastModified() error = %v", err)
+	}
+	if first.IsZero() {
+		t.Errorf("GetLastModified() got zero time")
+	}
+	time.Sleep(10 * time.Millisecond)
+	write(t, be, map[string]string{"/foo.json": "[]"})
+	second, err := be.GetLastModified(ctx, "/foo.json")
+	if err != nil {
+		t.Fatalf("GetLastModified() error = %v", err)
+	}
+	if !second.After(first) {
+		t.Errorf("GetLastModified() got = %v after rewrite, want after %v", second, first)
+	}
+}
+
+func testDelete(t *testing.T, be backend.Backend) {
+	ctx := context.TODO()
+	write(t, be, map[string]string{"/foo/bar.json": "{}", "/foo/baz.json": "{}"})
+	if err := be.Delete(ctx, "/foo/bar.json"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := be.Get(ctx, "/foo/bar.json"); !stderrors.Is(err, os.ErrNotExist) {
+		t.Errorf("Get() after Delete() error = %v, want %v", err, os.ErrNotExist)
+	}
+	if ok, _ := be.Exists(ctx, "/foo/bar.json"); ok {
+		t.Errorf("Exists() after Delete() got = true")
+	}
+	if err := be.Delete(ctx, "/foo/bar.json"); !stderrors.Is(err, os.ErrNotExist) {
+		t.Errorf("second Delete() error = %v, want %v", err, os.ErrNotExist)
+	}
+	if _, err := be.Get(ctx, "/foo/baz.json"); err != nil {
+		t.Errorf("Delete() removed sibling: %v", err)
+	}
+}
+
+// testDeleteDirectory checks that deleting a directory that is not empty fails with a backend.DeleteDirectoryError and
+// leaves the documents in it intact
+func testDeleteDirectory(t *testing.T, be backend.Backend) {
+	ctx := context.TODO()
+	write(t, be, map[string]string{"/foo/bar.json": "{}", "/foo/baz/qux.json": "{}"})
+	for _, path := range []string{"/foo", "/foo/baz"} {
+		err := be.Delete(ctx, path)
+		var deleteDirectoryError *backend.DeleteDirectoryError
+		if !stderrors.As(err, &deleteDirectoryError) {
+			t.Errorf("Delete(%s) error = %v, want %T", path, err, deleteDirectoryError)
+		}
+	}
+	for _, path := range []string{"/foo/bar.json", "/foo/baz/qux.json"} {
+		if _, err := be.Get(ctx, path); err != nil {
+			t.Errorf("Get(%s) after deleting its directory error = %v", path, err)
+		}
+	}
+}
+
+func testListTypes(t *testing.T, be backend.Backend) {
+	fbe, ok := be.(backend.FileBackend)
+	if !ok {
+		t.Skip("backend does not implement backend.FileBackend")
+	}
+	ctx := context.TODO()
+	write(t, be, map[string]string{
+		"/foo/bar.json":     "{}",
+		"/foo/baz/qux.json": "{}",
+		"/foo/qux/bar.json": "{}",
+	})
+	tests := []struct {
+		path string
+		mode fs.FileMode
+		want []string
+	}{
+		{"/", fs.ModeDir, []string{"foo"}},
+		{"/foo", fs.ModeDir, []string{"baz", "qux"}},
+		{"/foo", 0, []string{"bar.json"}},
+		{"/foo/baz", fs.ModeDir, []string{}},
+	}
+	for _, tt := range tests {
+		got, err := fbe.ListTypes(ctx, tt.path, tt.mode)
+		if err != nil {
+			t.Errorf("ListTypes(%s, %v) error = %v", tt.path, tt.mode, err)
+			continue
+		}
+		if got = sorted(got); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ListTypes(%s, %v) got = %v, want %v", tt.path, tt.mode, got, tt.want)
+		}
+	}
+	if _, err := fbe.ListTypes(ctx, "/missing", fs.ModeDir); !stderrors.Is(err, os.ErrNotExist) {
+		t.Errorf("ListTypes(/missing) error = %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func testWalk(t *testing.T, be backend.Backend) {
+	_, walker := be.(backend.Walker)
+	_, fileBackend := be.(backend.FileBackend)
+	if !walker && !fileBackend {
+		t.Skip("backend implements neither backend.Walker nor backend.FileBackend")
+	}
+	ctx := context.TODO()
+	write(t, be, map[string]string{
+		"/a.json":       "{}",
+		"/a/b.json":     "{}",
+		"/b/c/d.json":   "{}",
+		"/b/c.json":     "{}",
+		"/b/a.json":     "{}",
+		"/z.json":       "{}",
+		"/zz/zzz.json":  "{}",
+		"/b/c/e/f.json": "{}",
+	})
+	tests := map[string][]string{
+		"/":  {"/a/b.json", "/a.json", "/b/a.json", "/b/c/d.json", "/b/c/e/f.json", "/b/c.json", "/z.json", "/zz/zzz.json"},
+		"/b": {"/b/a.json", "/b/c/d.json", "/b/c/e/f.json", "/b/c.json"},
+	}
+	for prefix, want := range tests {
+		var got []string
+		err := backend.Walk(ctx, be, prefix, func(path string) error {
+			got = append(got, path)
+			return nil
+		})
+		if err != nil {
+			t.Errorf("Walk(%s) error = %v", prefix, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Walk(%s) got = %v, want %v", prefix, got, want)
+		}
+	}
+	stop := stderrors.New("stop")
+	visited := 0
+	err := backend.Walk(ctx, be, "/", func(path string) error {
+		visited++
+		return stop
+	})
+	if err != stop || visited != 1 {
+		t.Errorf("Walk() with failing callback error = %v after %d documents, want %v after 1", err, visited, stop)
+	}
+	if err := backend.Walk(ctx, be, "/missing", func(string) error { return nil }); !stderrors.Is(err, os.ErrNotExist) {
+		t.Errorf("Walk(/missing) error = %v, want %v", err, os.ErrNotExist)
+	}
+	if err := backend.Walk(ctx, be, "/../..", func(string) error { return nil }); err != errors.ErrorInvalidPath {
+		t.Errorf("Walk(/../..) error = %v, want %v", err, errors.ErrorInvalidPath)
+	}
+}
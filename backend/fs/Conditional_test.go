@@ -0,0 +1,59 @@
+package fs
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/skroczek/go-simple-json-store/backend"
+	"github.com/skroczek/go-simple-json-store/errors"
+)
+
+func testConditional(t *testing.T, be backend.ConditionalBackend) {
+	ctx := context.TODO()
+	if err := be.WriteIfRevision(ctx, "/counter.json", []byte("0"), "x"); err != errors.ErrorRevisionMismatch {
+		t.Errorf("WriteIfRevision() of missing document with revision error = %v", err)
+	}
+	if err := be.WriteIfRevision(ctx, "/counter.json", []byte("0"), ""); err != nil {
+		t.Fatalf("WriteIfRevision() of new document error = %v", err)
+	}
+	if err := be.WriteIfRevision(ctx, "/counter.json", []byte("0"), ""); err != errors.ErrorRevisionMismatch {
+		t.Errorf("WriteIfRevision() of existing document without revision error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				data, revision, err := be.GetWithRevision(ctx, "/counter.json")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				n, _ := strconv.Atoi(string(data))
+				err = be.WriteIfRevision(ctx, "/counter.json", []byte(strconv.Itoa(n+1)), revision)
+				if err != errors.ErrorRevisionMismatch {
+					if err != nil {
+						t.Error(err)
+					}
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if data, _ := be.Get(ctx, "/counter.json"); string(data) != "8" {
+		t.Errorf("counter after concurrent increments = %s, want 8", data)
+	}
+}
+
+func TestMemory_Conditional(t *testing.T) {
+	testConditional(t, NewMemory())
+}
+
+func TestFilesystemBackend_Conditional(t *testing.T) {
+	testConditional(t, NewFilesystemBackend(t.TempDir()))
+}
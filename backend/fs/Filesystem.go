@@ -2,24 +2,23 @@ package fs
 
 import (
 	"context"
+	stderrors "errors"
 	"fmt"
+	"github.com/skroczek/go-simple-json-store/backend"
+	"github.com/skroczek/go-simple-json-store/errors"
 	"io/fs"
+	"log"
 	goos "os"
 	"path/filepath"
 	"syscall"
 	"time"
 )
 
-type DeleteDirectoryError struct {
-	Path string
-}
+// DeleteDirectoryError is kept here for compatibility, see backend.DeleteDirectoryError
+type DeleteDirectoryError = backend.DeleteDirectoryError
 
 func NewDeleteDirectoryError(path string) *DeleteDirectoryError {
-	return &DeleteDirectoryError{Path: path}
-}
-
-func (d *DeleteDirectoryError) Error() string {
-	return "cannot delete directory " + d.Path
+	return backend.NewDeleteDirectoryError(path)
 }
 
 type FilesystemOption func(*FilesystemBackend)
@@ -36,47 +35,204 @@ func WithCreateDirs() FilesystemOption {
 	}
 }
 
+// WithInotify watches the tree with inotify for changes made by other processes, e.g. an operator editing a document.
+// They are reported to the watchers like changes made through the backend, and documents that are not valid JSON are
+// logged. It is only supported on Linux. Close stops watching.
+func WithInotify() FilesystemOption {
+	return func(f *FilesystemBackend) {
+		f.options |= watchInotify
+	}
+}
+
 type filesystemOption uint8
 
 const (
 	// FilesystemBackendConfig is the default configuration for the filesystem backend
 	createDirs filesystemOption = 1 << iota
 	deleteEmptyDirs
+	watchInotify
+	fileLocking
+	checkPermissions
 )
 
 type FilesystemBackend struct {
 	Root    string
 	options filesystemOption
+	events  *backend.EventHub
+	// expected and inotify are only set with WithInotify
+	expected *expectations
+	inotify  *inotifyWatcher
+	// lockTimeout is only used with WithFileLocking
+	lockTimeout time.Duration
+	fileMode    fs.FileMode
+	dirMode     fs.FileMode
+	gid         int
+	hasGroup    bool
 }
 
 func (f FilesystemBackend) Exists(ctx context.Context, path string) (bool, error) {
+	path, err := documentPath(path)
+	if err != nil {
+		return false, err
+	}
 	stat, err := goos.Stat(filepath.Join(f.Root, path))
 	if err != nil {
+		if goos.IsNotExist(err) {
+			return false, nil
+		}
 		return false, err
 	}
 	return !stat.IsDir(), nil
 }
 
 func (f FilesystemBackend) Get(ctx context.Context, path string) ([]byte, error) {
+	path, err := documentPath(path)
+	if err != nil {
+		return nil, err
+	}
 	return goos.ReadFile(filepath.Join(f.Root, path))
 }
 
 func (f FilesystemBackend) Write(ctx context.Context, path string, data []byte) error {
+	path, err := documentPath(path)
+	if err != nil {
+		return err
+	}
 	fullPath := filepath.Join(f.Root, path)
-	if f.options&createDirs != 0 {
-		dir := filepath.Dir(fullPath)
-		if err := goos.MkdirAll(dir, 0755); err != nil {
+	unlock, err := f.lockDocument(ctx, fullPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return f.write(fullPath, data)
+}
+
+// write replaces the document at fullPath and publishes the change. The caller must hold the document lock.
+func (f FilesystemBackend) write(fullPath string, data []byte) error {
+	eventType := backend.EventUpdate
+	if _, err := goos.Stat(fullPath); goos.IsNotExist(err) {
+		eventType = backend.EventCreate
+	}
+	cancel := f.expected.expect(fullPath)
+	// another process may delete an empty parent directory between its creation and the write, so the directories
+	// are created again then
+	for attempt := 1; ; attempt++ {
+		if f.options&createDirs != 0 {
+			if err := f.mkdirAll(filepath.Dir(fullPath)); err != nil {
+				cancel()
+				return err
+			}
+		}
+		err := writeFileAtomicWith(fullPath, data, f.filePerm(), f.prepareFile(fullPath, data))
+		if err == nil {
+			break
+		}
+		if f.options&createDirs == 0 || !goos.IsNotExist(err) || attempt == 3 {
+			cancel()
 			return err
 		}
 	}
-	return goos.WriteFile(fullPath, data, 0644)
+	modTime := time.Now()
+	if info, err := goos.Stat(fullPath); err == nil {
+		modTime = info.ModTime()
+	}
+	f.publish(eventType, fullPath, modTime)
+	return nil
+}
+
+// publish reports a change of the document at fullPath to the watchers
+func (f FilesystemBackend) publish(eventType backend.EventType, fullPath string, modTime time.Time) {
+	if f.events == nil {
+		return
+	}
+	rel, err := filepath.Rel(filepath.Clean(f.Root), fullPath)
+	if err != nil {
+		return
+	}
+	f.events.Publish(backend.Event{Type: eventType, Path: "/" + filepath.ToSlash(rel), ModTime: modTime})
+}
+
+// Watch reports the changes of documents below prefix that are made through this backend, see backend.Watcher
+func (f FilesystemBackend) Watch(ctx context.Context, prefix string) (<-chan backend.Event, error) {
+	if f.events == nil {
+		return nil, fmt.Errorf("watching requires a backend created by NewFilesystemBackend")
+	}
+	return f.events.Watch(ctx, prefix)
+}
+
+func (f FilesystemBackend) GetWithRevision(ctx context.Context, path string) ([]byte, string, error) {
+	data, err := f.Get(ctx, path)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, backend.ContentRevision(data), nil
+}
+
+// WriteIfRevision replaces the document if its content still matches revision. The check and the write are atomic
+// with respect to other writes of the same process, and with WithFileLocking of other processes as well.
+func (f FilesystemBackend) WriteIfRevision(ctx context.Context, path string, data []byte, revision string) error {
+	path, err := documentPath(path)
+	if err != nil {
+		return err
+	}
+	fullPath := filepath.Join(f.Root, path)
+	unlock, err := f.lockDocument(ctx, fullPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	current, err := goos.ReadFile(fullPath)
+	if err != nil && !goos.IsNotExist(err) {
+		return err
+	}
+	if (err == nil) != (revision != "") || (err == nil && backend.ContentRevision(current) != revision) {
+		return errors.ErrorRevisionMismatch
+	}
+	return f.write(fullPath, data)
 }
 
+// Delete deletes a document, or an empty directory with WithDeleteEmptyDirs. The root cannot be deleted.
 func (f FilesystemBackend) Delete(ctx context.Context, path string) error {
+	path, err := directoryPath(path)
+	if err != nil {
+		return err
+	}
 	if path == "" {
-		return fmt.Errorf("cannot delete root")
+		return errors.ErrorInvalidPath
 	}
 	fullPath := filepath.Join(f.Root, path)
+	if err := f.remove(ctx, path, fullPath); err != nil {
+		return err
+	}
+	return f.deleteParent(ctx, fullPath)
+}
+
+// deleteParent removes the parent directory of fullPath if it is empty and WithDeleteEmptyDirs is set
+func (f FilesystemBackend) deleteParent(ctx context.Context, fullPath string) error {
+	parentPath := filepath.Dir(fullPath)
+	if parentPath != filepath.Clean(f.Root) && f.options&deleteEmptyDirs != 0 {
+		rel, err := filepath.Rel(f.Root, parentPath)
+		if err != nil {
+			return err
+		}
+		err = f.Delete(ctx, filepath.ToSlash(rel))
+		var deleteDirectoryError *DeleteDirectoryError
+		if stderrors.As(err, &deleteDirectoryError) {
+			// the parent still has other entries
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// remove deletes a file or an empty directory. A directory that is not empty is reported as DeleteDirectoryError.
+func (f FilesystemBackend) remove(ctx context.Context, path, fullPath string) error {
+	return f.removeIf(ctx, path, fullPath, nil)
+}
+
+// removeIf is remove, but calls check with the lock of the file held and only removes it if check returns nil
+func (f FilesystemBackend) removeIf(ctx context.Context, path, fullPath string, check func() error) error {
 	fileInfo, err := goos.Stat(fullPath)
 	if err != nil {
 		return err
@@ -84,27 +240,46 @@ func (f FilesystemBackend) Delete(ctx context.Context, path string) error {
 	if fileInfo.IsDir() && f.options&deleteEmptyDirs == 0 {
 		return NewDeleteDirectoryError(path)
 	}
-	if err := goos.Remove(fullPath); err != nil {
-		if _, ok := err.(*DeleteDirectoryError); ok {
-			return nil
+	var unlock func()
+	if fileInfo.IsDir() {
+		unlock, err = f.lockDirectory(ctx, fullPath)
+	} else {
+		unlock, err = f.lockDocument(ctx, fullPath)
+	}
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if check != nil {
+		if err := check(); err != nil {
+			return err
 		}
+	}
+	cancel := func() {}
+	if !fileInfo.IsDir() {
+		cancel = f.expected.expect(fullPath)
+	}
+	if err := goos.Remove(fullPath); err != nil {
+		cancel()
 		if err, ok := err.(*goos.PathError); ok {
 			// TODO: we need some windows specific code here
 			if err.Err == syscall.ENOTEMPTY {
-				// ignore not empty error
-				return nil
+				return NewDeleteDirectoryError(path)
 			}
 		}
 		return err
 	}
-	parentPath := filepath.Dir(fullPath)
-	if parentPath != f.Root {
-		return f.Delete(ctx, parentPath[len(f.Root)+1:])
+	if !fileInfo.IsDir() {
+		f.publish(backend.EventDelete, fullPath, time.Now())
 	}
 	return nil
 }
 
 func (f FilesystemBackend) List(ctx context.Context, path string) ([]string, error) {
+	path, err := directoryPath(path)
+	if err != nil {
+		return nil, err
+	}
 	path = filepath.Join(f.Root, path)
 	var fileNames []string
 	files, err := goos.ReadDir(path)
@@ -112,7 +287,7 @@ func (f FilesystemBackend) List(ctx context.Context, path string) ([]string, err
 		return nil, err
 	}
 	for _, file := range files {
-		if file.Type() == fs.ModeDir {
+		if file.Type() == fs.ModeDir || isInternal(file.Name()) {
 			continue
 		}
 		if filepath.Ext(file.Name()) == ".json" {
@@ -123,6 +298,10 @@ func (f FilesystemBackend) List(ctx context.Context, path string) ([]string, err
 }
 
 func (f FilesystemBackend) ListTypes(ctx context.Context, path string, mode fs.FileMode) ([]string, error) {
+	path, err := directoryPath(path)
+	if err != nil {
+		return nil, err
+	}
 	path = filepath.Join(f.Root, path)
 	list := make([]string, 0)
 	files, err := goos.ReadDir(path)
@@ -130,7 +309,7 @@ func (f FilesystemBackend) ListTypes(ctx context.Context, path string, mode fs.F
 		return nil, err
 	}
 	for _, file := range files {
-		if file.Type() != mode {
+		if file.Type() != mode || isInternal(file.Name()) {
 			continue
 		}
 		list = append(list, file.Name())
@@ -139,14 +318,54 @@ func (f FilesystemBackend) ListTypes(ctx context.Context, path string, mode fs.F
 }
 
 func (f FilesystemBackend) GetLastModified(ctx context.Context, path string) (time.Time, error) {
+	path, err := documentPath(path)
+	if err != nil {
+		return time.Time{}, err
+	}
 	info, err := goos.Stat(filepath.Join(f.Root, path))
-	return info.ModTime(), err
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
 }
 
+// NewFilesystemBackend returns a FilesystemBackend. It panics if the tree cannot be watched with WithInotify, use
+// OpenFilesystemBackend to handle that error.
 func NewFilesystemBackend(root string, options ...FilesystemOption) *FilesystemBackend {
-	b := &FilesystemBackend{Root: root}
+	b, err := OpenFilesystemBackend(root, options...)
+	if err != nil {
+		log.Panicf("Error: %+v", err)
+	}
+	return b
+}
+
+// OpenFilesystemBackend returns a FilesystemBackend, or an error if the tree cannot be watched with WithInotify
+func OpenFilesystemBackend(root string, options ...FilesystemOption) (*FilesystemBackend, error) {
+	b := &FilesystemBackend{Root: root, events: backend.NewEventHub()}
 	for _, option := range options {
 		option(b)
 	}
-	return b
+	notBefore := time.Now()
+	if b.options&fileLocking != 0 {
+		notBefore = notBefore.Add(-staleTempAge)
+	}
+	removeTempFiles(root, notBefore)
+	b.recoverJournal()
+	if b.options&checkPermissions != 0 {
+		b.logPermissions()
+	}
+	if b.options&watchInotify != 0 {
+		b.expected = &expectations{}
+		watcher, err := startInotify(*b)
+		if err != nil {
+			return nil, fmt.Errorf("unable to watch %s: %w", root, err)
+		}
+		b.inotify = watcher
+	}
+	return b, nil
+}
+
+// Close stops watching the tree, see WithInotify
+func (f FilesystemBackend) Close() error {
+	return f.inotify.close()
 }
@@ -0,0 +1,160 @@
+package fs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	stderrors "errors"
+	"io"
+	goos "os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skroczek/go-simple-json-store/backend"
+)
+
+// attributePrefix is the prefix of the extended attributes the backend keeps for a document. The creation time and
+// the user metadata are stored in extended attributes, because an atomic write replaces the file and with it the
+// birth time the file system records.
+const attributePrefix = "user.gsjs."
+
+const (
+	createdAttribute  = "created"
+	metadataAttribute = "meta."
+	// hashAttribute holds the modification time and the content hash of the file when it was written, so Stat does
+	// not have to read the file. A file changed in place by another program has a different modification time and
+	// is hashed again.
+	hashAttribute = "hash"
+)
+
+// errUnsupported is returned by writeAttributes if the platform or the file system has no extended attributes
+var errUnsupported = stderrors.New("extended attributes are not supported")
+
+// ignoreUnsupported drops errUnsupported, for attributes the backend can do without
+func ignoreUnsupported(err error) error {
+	if err == errUnsupported {
+		return nil
+	}
+	return err
+}
+
+// preserveAttributes returns a function that copies the attributes of the document at fullPath to the file that
+// replaces it with data. A new document gets the current time as creation time. File systems without support for
+// extended attributes are tolerated, Stat falls back to the modification time as creation time and hashes the file
+// there.
+func preserveAttributes(fullPath string, data []byte) func(tmp *goos.File) error {
+	return func(tmp *goos.File) error {
+		attributes, err := readAttributes(fullPath)
+		if err != nil && !goos.IsNotExist(err) {
+			return err
+		}
+		if attributes == nil {
+			attributes = make(map[string]string)
+		}
+		if _, ok := attributes[createdAttribute]; !ok {
+			attributes[createdAttribute] = time.Now().UTC().Format(time.RFC3339Nano)
+		}
+		info, err := tmp.Stat()
+		if err != nil {
+			return err
+		}
+		attributes[hashAttribute] = hashValue(info.ModTime(), backend.ContentRevision(data))
+		return ignoreUnsupported(writeAttributes(tmp.Name(), attributes))
+	}
+}
+
+func hashValue(modTime time.Time, hash string) string {
+	return strconv.FormatInt(modTime.UnixNano(), 10) + ":" + hash
+}
+
+// Stat returns the metadata of a document. The hash is taken from the extended attributes written along with the
+// document. Without them it is computed by streaming the file, the content is not loaded into memory at once.
+func (f FilesystemBackend) Stat(ctx context.Context, path string) (backend.DocumentInfo, error) {
+	path, err := documentPath(path)
+	if err != nil {
+		return backend.DocumentInfo{}, err
+	}
+	fullPath := filepath.Join(f.Root, path)
+	file, err := goos.Open(fullPath)
+	if err != nil {
+		return backend.DocumentInfo{}, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return backend.DocumentInfo{}, err
+	}
+	if info.IsDir() {
+		return backend.DocumentInfo{}, goos.ErrNotExist
+	}
+	result := backend.DocumentInfo{
+		Size:       info.Size(),
+		ModTime:    info.ModTime(),
+		CreateTime: info.ModTime(),
+	}
+	attributes, err := readAttributes(fullPath)
+	if err != nil {
+		return backend.DocumentInfo{}, err
+	}
+	if value, ok := attributes[hashAttribute]; ok {
+		if modTime, hash, ok := strings.Cut(value, ":"); ok && modTime == strconv.FormatInt(info.ModTime().UnixNano(), 10) {
+			result.Hash = hash
+		}
+	}
+	if result.Hash == "" {
+		h := sha256.New()
+		if _, err = io.Copy(h, file); err != nil {
+			return backend.DocumentInfo{}, err
+		}
+		result.Hash = hex.EncodeToString(h.Sum(nil))
+	}
+	for key, value := range attributes {
+		if key == createdAttribute {
+			if created, err := time.Parse(time.RFC3339Nano, value); err == nil {
+				result.CreateTime = created
+			}
+			continue
+		}
+		if strings.HasPrefix(key, metadataAttribute) {
+			if result.Metadata == nil {
+				result.Metadata = make(map[string]string)
+			}
+			result.Metadata[strings.TrimPrefix(key, metadataAttribute)] = value
+		}
+	}
+	return result, nil
+}
+
+// SetMetadata replaces the user metadata of a document. The metadata is stored in extended attributes and kept when
+// the document is written again. It fails if the file system does not support extended attributes.
+func (f FilesystemBackend) SetMetadata(ctx context.Context, path string, metadata map[string]string) error {
+	path, err := documentPath(path)
+	if err != nil {
+		return err
+	}
+	fullPath := filepath.Join(f.Root, path)
+	unlock, err := f.lockDocument(ctx, fullPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, err = goos.Stat(fullPath); err != nil {
+		return err
+	}
+	attributes, err := readAttributes(fullPath)
+	if err != nil {
+		return err
+	}
+	updated := make(map[string]string)
+	for _, key := range []string{createdAttribute, hashAttribute} {
+		if value, ok := attributes[key]; ok {
+			updated[key] = value
+		}
+	}
+	for key, value := range metadata {
+		updated[metadataAttribute+key] = value
+	}
+	return writeAttributes(fullPath, updated)
+}
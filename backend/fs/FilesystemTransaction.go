@@ -0,0 +1,230 @@
+package fs
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"log"
+	goos "os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/skroczek/go-simple-json-store/backend"
+	"github.com/skroczek/go-simple-json-store/errors"
+)
+
+// journalDir is the directory below the root where the journals of transactions being applied are kept
+const journalDir = internalPrefix + "journal"
+
+var journalSequence atomic.Uint64
+
+// journal records the state of the documents of a transaction before it is applied, so that a transaction that
+// was not completely applied can be rolled back
+type journal struct {
+	Undo []undoEntry `json:"undo"`
+}
+
+type undoEntry struct {
+	Path string `json:"path"`
+	// Existed and Data are the document before the transaction
+	Existed bool   `json:"existed"`
+	Data    []byte `json:"data,omitempty"`
+	// Revision is the content revision the transaction leaves, empty if it deletes the document
+	Revision string `json:"revision"`
+}
+
+// Begin starts a transaction. On commit, the previous state of the documents is first written to a journal file and
+// then the operations are applied. If applying them fails, or the process crashes while applying them, the documents
+// are restored from the journal, so either all or none of the operations take effect. A document that was changed
+// again after the transaction is not restored. Transactions are not isolated from concurrent writes.
+func (f FilesystemBackend) Begin(ctx context.Context) (backend.Transaction, error) {
+	return backend.NewStagedTransaction(validateDocumentPath, f.commit), nil
+}
+
+func (f FilesystemBackend) commit(ctx context.Context, ops []backend.TransactionOp) error {
+	// everything that can fail for a reason other than the disk is checked before the journal is written
+	for _, op := range ops {
+		fullPath := filepath.Join(f.Root, op.Path)
+		if op.Delete {
+			info, err := goos.Stat(fullPath)
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return NewDeleteDirectoryError(op.Path)
+			}
+			continue
+		}
+		if f.options&createDirs == 0 {
+			if _, err := goos.Stat(filepath.Dir(fullPath)); err != nil {
+				return err
+			}
+		}
+	}
+	unlock, err := f.fileLock(ctx, journalLockKey, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	undo, err := f.undo(ops)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(journal{Undo: undo})
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(f.Root, journalDir)
+	if err = goos.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	name := filepath.Join(dir, fmt.Sprintf("%020d-%d.json", time.Now().UnixNano(), journalSequence.Add(1)))
+	if err = writeFileAtomic(name, data, 0600); err != nil {
+		return err
+	}
+	// stopping halfway would leave the transaction partially applied, so the caller cannot cancel from here on
+	ctx = context.WithoutCancel(ctx)
+	if err = f.apply(ctx, ops); err != nil {
+		if rollbackErr := f.rollback(ctx, undo); rollbackErr != nil {
+			return fmt.Errorf("transaction not applied and not rolled back, it is rolled back on the next start: %w",
+				stderrors.Join(err, rollbackErr))
+		}
+		if removeErr := goos.Remove(name); removeErr != nil {
+			log.Printf("unable to remove journal %s: %v", name, removeErr)
+		}
+		return fmt.Errorf("transaction rolled back: %w", err)
+	}
+	if err = goos.Remove(name); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// undo reads the state of the documents of ops before they are applied
+func (f FilesystemBackend) undo(ops []backend.TransactionOp) ([]undoEntry, error) {
+	var undo []undoEntry
+	index := make(map[string]int)
+	for _, op := range ops {
+		i, ok := index[op.Path]
+		if !ok {
+			data, err := goos.ReadFile(filepath.Join(f.Root, op.Path))
+			if err != nil && !goos.IsNotExist(err) {
+				return nil, err
+			}
+			i = len(undo)
+			index[op.Path] = i
+			undo = append(undo, undoEntry{Path: op.Path, Existed: err == nil, Data: data})
+		}
+		undo[i].Revision = ""
+		if !op.Delete {
+			undo[i].Revision = backend.ContentRevision(op.Data)
+		}
+	}
+	return undo, nil
+}
+
+// apply executes the operations of a transaction
+func (f FilesystemBackend) apply(ctx context.Context, ops []backend.TransactionOp) error {
+	for _, op := range ops {
+		if op.Delete {
+			if err := f.Delete(ctx, op.Path); err != nil && !goos.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		if err := f.Write(ctx, op.Path, op.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollback restores the documents of a journal. Only documents that are still in the state the transaction left are
+// restored, a document that was not reached by the transaction or that was changed since then is kept.
+func (f FilesystemBackend) rollback(ctx context.Context, undo []undoEntry) error {
+	for _, entry := range undo {
+		var err error
+		if entry.Existed {
+			err = f.WriteIfRevision(ctx, entry.Path, entry.Data, entry.Revision)
+		} else if entry.Revision != "" {
+			err = f.deleteIfRevision(ctx, entry.Path, entry.Revision)
+		}
+		if err != nil && err != errors.ErrorRevisionMismatch && !goos.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteIfRevision deletes the document if its content still matches revision
+func (f FilesystemBackend) deleteIfRevision(ctx context.Context, path, revision string) error {
+	path, err := documentPath(path)
+	if err != nil {
+		return err
+	}
+	fullPath := filepath.Join(f.Root, path)
+	err = f.removeIf(ctx, path, fullPath, func() error {
+		current, err := goos.ReadFile(fullPath)
+		if err != nil {
+			return err
+		}
+		if backend.ContentRevision(current) != revision {
+			return errors.ErrorRevisionMismatch
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return f.deleteParent(ctx, fullPath)
+}
+
+// recoverJournal rolls back the transactions that were not completely applied before a crash
+func (f FilesystemBackend) recoverJournal() {
+	unlock, err := f.fileLock(context.Background(), journalLockKey, true)
+	if err != nil {
+		log.Printf("unable to lock journal: %v", err)
+		return
+	}
+	defer unlock()
+	dir := filepath.Join(f.Root, journalDir)
+	files, err := goos.ReadDir(dir)
+	if err != nil {
+		if !goos.IsNotExist(err) {
+			log.Printf("unable to read journal %s: %v", dir, err)
+		}
+		return
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		if !file.IsDir() && filepath.Ext(file.Name()) == ".json" {
+			names = append(names, file.Name())
+		}
+	}
+	// newer transactions are rolled back first, they may have changed the documents of older ones
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	for _, name := range names {
+		data, err := goos.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			log.Printf("unable to read journal %s: %v", name, err)
+			return
+		}
+		var j journal
+		if err = json.Unmarshal(data, &j); err != nil {
+			log.Printf("unable to parse journal %s: %v", name, err)
+			return
+		}
+		if err = f.rollback(context.Background(), j.Undo); err != nil {
+			log.Printf("unable to roll back transaction %s: %v", name, err)
+			return
+		}
+		if err = goos.Remove(filepath.Join(dir, name)); err != nil {
+			log.Printf("unable to remove journal %s: %v", name, err)
+			return
+		}
+		log.Printf("rolled back transaction %s", name)
+	}
+}
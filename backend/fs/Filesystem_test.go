@@ -0,0 +1,139 @@
+package fs
+
+import (
+	"context"
+	"io/fs"
+	goos "os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/skroczek/go-simple-json-store/errors"
+)
+
+func TestFilesystemBackend_Write(t *testing.T) {
+	root := t.TempDir()
+	f := NewFilesystemBackend(root, WithCreateDirs())
+	for _, content := range []string{`{"name":"foo"}`, `{"name":"bar"}`} {
+		if err := f.Write(context.TODO(), "foo/bar.json", []byte(content)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		got, err := f.Get(context.TODO(), "foo/bar.json")
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if string(got) != content {
+			t.Errorf("Get() got = %s, want %s", got, content)
+		}
+	}
+	entries, err := goos.ReadDir(filepath.Join(root, "foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Write() left %d files behind, want 1", len(entries))
+	}
+}
+
+func TestNewFilesystemBackend_RemovesTempFiles(t *testing.T) {
+	root := t.TempDir()
+	if err := goos.MkdirAll(filepath.Join(root, "foo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"foo/bar.json":             `{}`,
+		"foo/" + tempPrefix + "1":  `{"trunc`,
+		tempPrefix + "2":           `{"trunc`,
+		"foo/" + tempPrefix + "ok": `{}`,
+	}
+	for name, content := range files {
+		if err := goos.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := NewFilesystemBackend(root)
+	for name := range files {
+		_, err := goos.Stat(filepath.Join(root, name))
+		if name == "foo/bar.json" {
+			if err != nil {
+				t.Errorf("NewFilesystemBackend() removed %s", name)
+			}
+			continue
+		}
+		if !goos.IsNotExist(err) {
+			t.Errorf("NewFilesystemBackend() did not remove %s", name)
+		}
+	}
+	list, err := f.List(context.TODO(), "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(list, []string{"bar.json"}) {
+		t.Errorf("List() got = %v, want [bar.json]", list)
+	}
+}
+
+func TestFilesystemBackend_ListTypes_HidesInternalFiles(t *testing.T) {
+	root := t.TempDir()
+	f := NewFilesystemBackend(root)
+	if err := goos.WriteFile(filepath.Join(root, "foo.json"), []byte(`{}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := goos.WriteFile(filepath.Join(root, tempPrefix+"1"), []byte(`{`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	list, err := f.ListTypes(context.TODO(), "", fs.FileMode(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(list, []string{"foo.json"}) {
+		t.Errorf("ListTypes() got = %v, want [foo.json]", list)
+	}
+}
+
+func TestFilesystemBackend_Walk_RejectsParent(t *testing.T) {
+	root := t.TempDir()
+	_ = goos.WriteFile(filepath.Join(root, "outside.json"), []byte(`{}`), 0644)
+	f := NewFilesystemBackend(filepath.Join(root, "store"), WithCreateDirs())
+	for _, prefix := range []string{"/..", "/../store/..", "../../etc"} {
+		err := f.Walk(context.TODO(), prefix, func(path string) error {
+			t.Errorf("Walk(%s) visited %s", prefix, path)
+			return nil
+		})
+		if err != errors.ErrorInvalidPath {
+			t.Errorf("Walk(%s) error = %v, want %v", prefix, err, errors.ErrorInvalidPath)
+		}
+	}
+}
+
+func TestFilesystemBackend_RejectsInternalPaths(t *testing.T) {
+	root := t.TempDir()
+	f := NewFilesystemBackend(root, WithCreateDirs())
+	for _, path := range []string{"/" + tempPrefix + "x.json", "/foo/" + tempPrefix + "x.json", "/" + internalPrefix + "foo/x.json"} {
+		if err := f.Write(context.TODO(), path, []byte(`{}`)); err != errors.ErrorInvalidPath {
+			t.Errorf("Write(%s) error = %v, want %v", path, err, errors.ErrorInvalidPath)
+		}
+		if _, err := f.Get(context.TODO(), path); err != errors.ErrorInvalidPath {
+			t.Errorf("Get(%s) error = %v, want %v", path, err, errors.ErrorInvalidPath)
+		}
+	}
+	entries, _ := goos.ReadDir(root)
+	if len(entries) != 0 {
+		t.Errorf("Write() of internal paths created %d files", len(entries))
+	}
+}
+
+func TestFilesystemBackend_Walk_VanishedDirectory(t *testing.T) {
+	root := t.TempDir()
+	f := NewFilesystemBackend(root, WithCreateDirs())
+	_ = f.Write(context.TODO(), "/a/x.json", []byte(`{}`))
+	_ = f.Write(context.TODO(), "/b/y.json", []byte(`{}`))
+	var visited []string
+	err := f.Walk(context.TODO(), "/", func(path string) error {
+		visited = append(visited, path)
+		return goos.RemoveAll(filepath.Join(root, "b"))
+	})
+	if err != nil || !reflect.DeepEqual(visited, []string{"/a/x.json"}) {
+		t.Errorf("Walk() with a directory deleted while walking = %v, %v", visited, err)
+	}
+}
@@ -0,0 +1,664 @@
+package fs
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"log"
+	goos "os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skroczek/go-simple-json-store/errors"
+)
+
+const (
+	opPut byte = iota + 1
+	opDelete
+	// opCompacted is the first record of a segment written by the compaction. All segments with a lower id are
+	// obsolete.
+	opCompacted
+)
+
+// recordHeaderSize is the size of crc (4), op (1), modification time (8), path length (2) and data length (4)
+const recordHeaderSize = 19
+
+const (
+	defaultMaxSegmentSize     = 64 << 20
+	defaultCompactionInterval = 10 * time.Minute
+	// compactionThreshold is the ratio of garbage in sealed segments above which the background compaction runs
+	compactionThreshold = 0.5
+)
+
+type LogOption func(*LogBackend)
+
+// WithMaxSegmentSize sets the size in bytes after which a new segment is started
+func WithMaxSegmentSize(size int64) LogOption {
+	return func(l *LogBackend) {
+		l.maxSegmentSize = size
+	}
+}
+
+// WithCompactionInterval sets how often the background compaction checks for garbage. A zero interval disables the
+// background compaction, Compact can still be called directly.
+func WithCompactionInterval(interval time.Duration) LogOption {
+	return func(l *LogBackend) {
+		l.compactionInterval = interval
+	}
+}
+
+type logEntry struct {
+	segment uint64
+	offset  int64
+	// record is the size of the whole record, size the size of the document data
+	record  int64
+	size    int64
+	modTime time.Time
+}
+
+func (e logEntry) dataOffset() int64 {
+	return e.offset + e.record - e.size
+}
+
+type segment struct {
+	file *goos.File
+	size int64
+	live int64
+}
+
+// LogBackend stores every write and delete as a record in an append-only segment file in a single directory. An
+// in-memory index points to the latest record of every document. The index is rebuilt on startup by replaying the
+// segments, sealed segments are compacted in the background.
+type LogBackend struct {
+	dir                string
+	maxSegmentSize     int64
+	compactionInterval time.Duration
+
+	mu    sync.RWMutex
+	index map[string]logEntry
+	// dirs maps every directory to the names of its entries and the number of documents below each entry
+	dirs     map[string]map[string]int
+	segments map[uint64]*segment
+	activeID uint64
+
+	compactMu sync.Mutex
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// NewLogBackend opens the log backend in dir and replays its segments. The directory is created if necessary.
+func NewLogBackend(dir string, options ...LogOption) (*LogBackend, error) {
+	l := &LogBackend{
+		dir:                dir,
+		maxSegmentSize:     defaultMaxSegmentSize,
+		compactionInterval: defaultCompactionInterval,
+		index:              make(map[string]logEntry),
+		dirs:               make(map[string]map[string]int),
+		segments:           make(map[uint64]*segment),
+	}
+	for _, option := range options {
+		option(l)
+	}
+	if err := goos.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	removeTempFiles(dir, time.Now())
+	if err := l.open(); err != nil {
+		l.closeFiles()
+		return nil, err
+	}
+	if l.compactionInterval > 0 {
+		l.stop = make(chan struct{})
+		l.done = make(chan struct{})
+		go l.compactionLoop()
+	}
+	return l, nil
+}
+
+func segmentName(id uint64) string {
+	return fmt.Sprintf("segment-%016d.log", id)
+}
+
+func segmentIDs(dir string) ([]uint64, error) {
+	files, err := goos.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint64
+	for _, file := range files {
+		var id uint64
+		if _, err := fmt.Sscanf(file.Name(), "segment-%016d.log", &id); err == nil && file.Name() == segmentName(id) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func (l *LogBackend) open() error {
+	ids, err := segmentIDs(l.dir)
+	if err != nil {
+		return err
+	}
+	// a compacted segment makes all older segments obsolete, they are left over from an interrupted compaction
+	for i := len(ids) - 1; i > 0; i-- {
+		compacted, err := isCompactedSegment(filepath.Join(l.dir, segmentName(ids[i])))
+		if err != nil {
+			return err
+		}
+		if compacted {
+			for _, id := range ids[:i] {
+				if err := goos.Remove(filepath.Join(l.dir, segmentName(id))); err != nil {
+					return err
+				}
+			}
+			ids = ids[i:]
+			break
+		}
+	}
+	if len(ids) == 0 {
+		ids = []uint64{1}
+	}
+	for i, id := range ids {
+		file, err := goos.OpenFile(filepath.Join(l.dir, segmentName(id)), goos.O_RDWR|goos.O_CREATE|goos.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		seg := &segment{file: file}
+		l.segments[id] = seg
+		if err := l.replay(id, seg, i == len(ids)-1); err != nil {
+			return err
+		}
+	}
+	l.activeID = ids[len(ids)-1]
+	return syncDir(l.dir)
+}
+
+func isCompactedSegment(name string) (bool, error) {
+	file, err := goos.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return false, nil
+	}
+	return header[4] == opCompacted, nil
+}
+
+// replay reads all records of a segment into the index. A torn record at the end of the last segment is the result
+// of a crash during a write and is truncated.
+func (l *LogBackend) replay(id uint64, seg *segment, last bool) error {
+	info, err := seg.file.Stat()
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(io.NewSectionReader(seg.file, 0, info.Size()))
+	var offset int64
+	for {
+		op, path, data, modTime, size, err := readRecord(r, info.Size()-offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if !last {
+				return fmt.Errorf("corrupt segment %s at offset %d: %w", segmentName(id), offset, err)
+			}
+			log.Printf("truncating torn record in segment %s at offset %d: %v", segmentName(id), offset, err)
+			if err := seg.file.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		switch op {
+		case opPut:
+			l.setEntry(path, logEntry{segment: id, offset: offset, record: size, size: int64(len(data)), modTime: modTime})
+		case opDelete:
+			l.removeEntry(path)
+		case opCompacted:
+			seg.live += size
+		}
+		offset += size
+		seg.size = offset
+	}
+	return nil
+}
+
+// readRecord reads the next record from r. remaining is the number of bytes left in the segment, it protects against
+// allocating huge buffers for a torn header.
+func readRecord(r io.Reader, remaining int64) (op byte, path string, data []byte, modTime time.Time, size int64, err error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err = io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("short record header")
+		}
+		return
+	}
+	pathLen := int(binary.LittleEndian.Uint16(header[13:15]))
+	dataLen := int64(binary.LittleEndian.Uint32(header[15:19]))
+	if recordHeaderSize+int64(pathLen)+dataLen > remaining {
+		err = fmt.Errorf("short record body")
+		return
+	}
+	body := make([]byte, int64(pathLen)+dataLen)
+	if _, err = io.ReadFull(r, body); err != nil {
+		err = fmt.Errorf("short record body")
+		return
+	}
+	checksum := crc32.NewIEEE()
+	checksum.Write(header[4:])
+	checksum.Write(body)
+	if checksum.Sum32() != binary.LittleEndian.Uint32(header[0:4]) {
+		err = fmt.Errorf("checksum mismatch")
+		return
+	}
+	op = header[4]
+	modTime = time.Unix(0, int64(binary.LittleEndian.Uint64(header[5:13])))
+	path = string(body[:pathLen])
+	data = body[pathLen:]
+	size = int64(len(header) + len(body))
+	return
+}
+
+func encodeRecord(op byte, path string, data []byte, modTime time.Time) []byte {
+	record := make([]byte, recordHeaderSize+len(path)+len(data))
+	record[4] = op
+	binary.LittleEndian.PutUint64(record[5:13], uint64(modTime.UnixNano()))
+	binary.LittleEndian.PutUint16(record[13:15], uint16(len(path)))
+	binary.LittleEndian.PutUint32(record[15:19], uint32(len(data)))
+	copy(record[recordHeaderSize:], path)
+	copy(record[recordHeaderSize+len(path):], data)
+	binary.LittleEndian.PutUint32(record[0:4], crc32.ChecksumIEEE(record[4:]))
+	return record
+}
+
+func (l *LogBackend) setEntry(path string, entry logEntry) {
+	l.removeEntry(path)
+	l.index[path] = entry
+	l.updateDirs(path, 1)
+	if seg, ok := l.segments[entry.segment]; ok {
+		seg.live += entry.record
+	}
+}
+
+func (l *LogBackend) removeEntry(path string) {
+	if old, ok := l.index[path]; ok {
+		if seg, ok := l.segments[old.segment]; ok {
+			seg.live -= old.record
+		}
+		delete(l.index, path)
+		l.updateDirs(path, -1)
+	}
+}
+
+// updateDirs adds delta to the document count of every entry on the way to path and drops entries and directories
+// that have no documents left
+func (l *LogBackend) updateDirs(path string, delta int) {
+	dir := ""
+	for {
+		name, rest, more := strings.Cut(path, "/")
+		entries := l.dirs[dir]
+		if entries == nil {
+			entries = make(map[string]int)
+			l.dirs[dir] = entries
+		}
+		entries[name] += delta
+		if entries[name] <= 0 {
+			delete(entries, name)
+		}
+		if len(entries) == 0 && dir != "" {
+			delete(l.dirs, dir)
+		}
+		if !more {
+			return
+		}
+		dir = strings.TrimPrefix(dir+"/"+name, "/")
+		path = rest
+	}
+}
+
+// append writes a record to the active segment and starts a new segment if the active one is full. It must be called
+// with the write lock held.
+func (l *LogBackend) append(record []byte) (uint64, int64, error) {
+	seg := l.segments[l.activeID]
+	if seg.size > 0 && seg.size+int64(len(record)) > l.maxSegmentSize {
+		file, err := goos.OpenFile(filepath.Join(l.dir, segmentName(l.activeID+1)), goos.O_RDWR|goos.O_CREATE|goos.O_APPEND, 0644)
+		if err != nil {
+			return 0, 0, err
+		}
+		if err := syncDir(l.dir); err != nil {
+			_ = file.Close()
+			return 0, 0, err
+		}
+		l.activeID++
+		seg = &segment{file: file}
+		l.segments[l.activeID] = seg
+	}
+	offset := seg.size
+	if _, err := seg.file.Write(record); err != nil {
+		// drop the partial record, so the next append starts at a record boundary
+		_ = seg.file.Truncate(offset)
+		return 0, 0, err
+	}
+	if err := seg.file.Sync(); err != nil {
+		return 0, 0, err
+	}
+	seg.size += int64(len(record))
+	return l.activeID, offset, nil
+}
+
+func (l *LogBackend) Exists(ctx context.Context, path string) (bool, error) {
+	path, err := documentPath(path)
+	if err != nil {
+		return false, err
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.index[path]
+	return ok, nil
+}
+
+func (l *LogBackend) Get(ctx context.Context, path string) ([]byte, error) {
+	path, err := documentPath(path)
+	if err != nil {
+		return nil, err
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	entry, ok := l.index[path]
+	if !ok {
+		return nil, goos.ErrNotExist
+	}
+	data := make([]byte, entry.size)
+	if _, err := l.segments[entry.segment].file.ReadAt(data, entry.dataOffset()); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (l *LogBackend) Write(ctx context.Context, path string, data []byte) error {
+	path, err := documentPath(path)
+	if err != nil {
+		return err
+	}
+	if len(path) > 0xffff {
+		return errors.ErrorInvalidPath
+	}
+	// the size of the document is stored in 32 bits
+	if int64(len(data)) > 0xffffffff {
+		return errors.ErrorDocumentTooLarge
+	}
+	modTime := time.Now()
+	record := encodeRecord(opPut, path, data, modTime)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	id, offset, err := l.append(record)
+	if err != nil {
+		return err
+	}
+	l.setEntry(path, logEntry{segment: id, offset: offset, record: int64(len(record)), size: int64(len(data)), modTime: modTime})
+	return nil
+}
+
+func (l *LogBackend) Delete(ctx context.Context, path string) error {
+	path, err := directoryPath(path)
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		return errors.ErrorInvalidPath
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.index[path]; !ok {
+		if l.isDir(path) {
+			return NewDeleteDirectoryError(path)
+		}
+		return goos.ErrNotExist
+	}
+	if _, _, err := l.append(encodeRecord(opDelete, path, nil, time.Now())); err != nil {
+		return err
+	}
+	l.removeEntry(path)
+	return nil
+}
+
+func (l *LogBackend) isDir(path string) bool {
+	_, ok := l.dirs[path]
+	return ok || path == ""
+}
+
+func (l *LogBackend) List(ctx context.Context, path string) ([]string, error) {
+	files, err := l.ListTypes(ctx, path, 0)
+	if err != nil {
+		return nil, err
+	}
+	var fileNames []string
+	for _, file := range files {
+		if filepath.Ext(file) == ".json" {
+			fileNames = append(fileNames, file)
+		}
+	}
+	return fileNames, nil
+}
+
+func (l *LogBackend) ListTypes(ctx context.Context, path string, mode fs.FileMode) ([]string, error) {
+	path, err := directoryPath(path)
+	if err != nil {
+		return nil, err
+	}
+	prefix := path + "/"
+	if path == "" {
+		prefix = ""
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if !l.isDir(path) {
+		return nil, goos.ErrNotExist
+	}
+	list := make([]string, 0)
+	for name := range l.dirs[path] {
+		if mode == fs.ModeDir && l.isDir(prefix+name) {
+			list = append(list, name)
+		}
+		if _, isFile := l.index[prefix+name]; mode == 0 && isFile {
+			list = append(list, name)
+		}
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+func (l *LogBackend) GetLastModified(ctx context.Context, path string) (time.Time, error) {
+	path, err := documentPath(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	entry, ok := l.index[path]
+	if !ok {
+		return time.Time{}, goos.ErrNotExist
+	}
+	return entry.modTime, nil
+}
+
+// Compact rewrites all sealed segments into a single segment that only contains the live documents. Writes are not
+// blocked while the documents are copied.
+func (l *LogBackend) Compact() error {
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+
+	l.mu.Lock()
+	if l.segments[l.activeID].size > 0 {
+		// seal the active segment, so everything written so far takes part in the compaction
+		file, err := goos.OpenFile(filepath.Join(l.dir, segmentName(l.activeID+1)), goos.O_RDWR|goos.O_CREATE|goos.O_APPEND, 0644)
+		if err != nil {
+			l.mu.Unlock()
+			return err
+		}
+		if err := syncDir(l.dir); err != nil {
+			_ = file.Close()
+			l.mu.Unlock()
+			return err
+		}
+		l.activeID++
+		l.segments[l.activeID] = &segment{file: file}
+	}
+	var sealed []uint64
+	for id := range l.segments {
+		if id < l.activeID {
+			sealed = append(sealed, id)
+		}
+	}
+	if len(sealed) == 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	sort.Slice(sealed, func(i, j int) bool { return sealed[i] < sealed[j] })
+	target := sealed[len(sealed)-1]
+	live := make(map[string]logEntry)
+	var paths []string
+	for path, entry := range l.index {
+		if entry.segment <= target {
+			live[path] = entry
+			paths = append(paths, path)
+		}
+	}
+	files := make(map[uint64]*goos.File)
+	for _, id := range sealed {
+		files[id] = l.segments[id].file
+	}
+	l.mu.Unlock()
+	sort.Strings(paths)
+
+	// sealed segments are immutable, so the live documents can be copied without holding the lock
+	tmp, err := goos.CreateTemp(l.dir, tempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = goos.Remove(tmp.Name())
+	}()
+	w := bufio.NewWriter(tmp)
+	marker := encodeRecord(opCompacted, "", nil, time.Now())
+	if _, err := w.Write(marker); err != nil {
+		return err
+	}
+	offset := int64(len(marker))
+	moved := make(map[string]logEntry, len(paths))
+	for _, path := range paths {
+		entry := live[path]
+		data := make([]byte, entry.size)
+		if _, err := files[entry.segment].ReadAt(data, entry.dataOffset()); err != nil {
+			return err
+		}
+		record := encodeRecord(opPut, path, data, entry.modTime)
+		if _, err := w.Write(record); err != nil {
+			return err
+		}
+		moved[path] = logEntry{segment: target, offset: offset, record: int64(len(record)), size: entry.size, modTime: entry.modTime}
+		offset += int64(len(record))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := goos.Rename(tmp.Name(), filepath.Join(l.dir, segmentName(target))); err != nil {
+		return err
+	}
+	if err := syncDir(l.dir); err != nil {
+		return err
+	}
+	// from here on the compacted segment is authoritative, a crash leaves only obsolete segments behind
+	file, err := goos.OpenFile(filepath.Join(l.dir, segmentName(target)), goos.O_RDWR|goos.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	for _, id := range sealed {
+		_ = l.segments[id].file.Close()
+		delete(l.segments, id)
+		if id != target {
+			if err := goos.Remove(filepath.Join(l.dir, segmentName(id))); err != nil {
+				log.Printf("unable to remove compacted segment %s: %v", segmentName(id), err)
+			}
+		}
+	}
+	l.segments[target] = &segment{file: file, size: offset, live: int64(len(marker))}
+	for path, entry := range moved {
+		// documents written or deleted during the compaction already have a newer record
+		if current, ok := l.index[path]; ok && current == live[path] {
+			l.setEntry(path, entry)
+		}
+	}
+	return syncDir(l.dir)
+}
+
+func (l *LogBackend) needsCompaction() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var size, garbage int64
+	for _, seg := range l.segments {
+		size += seg.size
+		garbage += seg.size - seg.live
+	}
+	return garbage > 0 && float64(garbage)/float64(size) >= compactionThreshold
+}
+
+func (l *LogBackend) compactionLoop() {
+	defer close(l.done)
+	ticker := time.NewTicker(l.compactionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !l.needsCompaction() {
+				continue
+			}
+			if err := l.Compact(); err != nil {
+				log.Printf("unable to compact log %s: %v", l.dir, err)
+			}
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// Close stops the background compaction and closes all segment files
+func (l *LogBackend) Close() error {
+	if l.stop != nil {
+		close(l.stop)
+		<-l.done
+		l.stop = nil
+	}
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closeFiles()
+}
+
+func (l *LogBackend) closeFiles() error {
+	var err error
+	for id, seg := range l.segments {
+		if cerr := seg.file.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(l.segments, id)
+	}
+	return err
+}
@@ -0,0 +1,141 @@
+package fs
+
+import (
+	"context"
+	"io/fs"
+	goos "os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func openLog(t *testing.T, dir string, options ...LogOption) *LogBackend {
+	t.Helper()
+	l, err := NewLogBackend(dir, append([]LogOption{WithCompactionInterval(0)}, options...)...)
+	if err != nil {
+		t.Fatalf("NewLogBackend() error = %v", err)
+	}
+	return l
+}
+
+func assertDocuments(t *testing.T, l *LogBackend, want map[string]string) {
+	t.Helper()
+	for path, content := range want {
+		got, err := l.Get(context.TODO(), path)
+		if content == "" {
+			if !goos.IsNotExist(err) {
+				t.Errorf("Get(%s) error = %v, want not exist", path, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Get(%s) error = %v", path, err)
+			continue
+		}
+		if string(got) != content {
+			t.Errorf("Get(%s) got = %s, want %s", path, got, content)
+		}
+	}
+}
+
+func TestLogBackend_Replay(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, dir, WithMaxSegmentSize(64))
+	ctx := context.TODO()
+	_ = l.Write(ctx, "foo/bar.json", []byte(`{"v":1}`))
+	_ = l.Write(ctx, "foo/baz.json", []byte(`{"v":2}`))
+	_ = l.Write(ctx, "foo/bar.json", []byte(`{"v":3}`))
+	_ = l.Write(ctx, "qux.json", []byte(`{"v":4}`))
+	if err := l.Delete(ctx, "qux.json"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	want := map[string]string{"foo/bar.json": `{"v":3}`, "foo/baz.json": `{"v":2}`, "qux.json": ""}
+	assertDocuments(t, l, want)
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	l = openLog(t, dir)
+	defer l.Close()
+	assertDocuments(t, l, want)
+	list, err := l.List(ctx, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(list, []string{"bar.json", "baz.json"}) {
+		t.Errorf("List() got = %v", list)
+	}
+}
+
+func TestLogBackend_Compact(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, dir, WithMaxSegmentSize(64))
+	ctx := context.TODO()
+	for i := 0; i < 10; i++ {
+		_ = l.Write(ctx, "foo.json", []byte{'0' + byte(i)})
+		_ = l.Write(ctx, "bar.json", []byte{'a' + byte(i)})
+	}
+	_ = l.Delete(ctx, "bar.json")
+	if err := l.Compact(); err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+	ids, _ := segmentIDs(dir)
+	if len(ids) != 2 {
+		t.Errorf("Compact() left %d segments, want 2", len(ids))
+	}
+	want := map[string]string{"foo.json": "9", "bar.json": ""}
+	assertDocuments(t, l, want)
+	_ = l.Write(ctx, "baz.json", []byte("x"))
+	_ = l.Close()
+
+	l = openLog(t, dir)
+	defer l.Close()
+	want["baz.json"] = "x"
+	assertDocuments(t, l, want)
+}
+
+func TestLogBackend_TornRecord(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, dir)
+	_ = l.Write(context.TODO(), "foo.json", []byte(`{"v":1}`))
+	_ = l.Write(context.TODO(), "bar.json", []byte(`{"v":2}`))
+	_ = l.Close()
+
+	name := filepath.Join(dir, segmentName(1))
+	info, err := goos.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := goos.Truncate(name, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+	l = openLog(t, dir)
+	defer l.Close()
+	assertDocuments(t, l, map[string]string{"foo.json": `{"v":1}`, "bar.json": ""})
+	if err := l.Write(context.TODO(), "bar.json", []byte(`{"v":3}`)); err != nil {
+		t.Fatal(err)
+	}
+	assertDocuments(t, l, map[string]string{"bar.json": `{"v":3}`})
+}
+
+func TestLogBackend_ListTypes(t *testing.T) {
+	l := openLog(t, t.TempDir())
+	defer l.Close()
+	ctx := context.TODO()
+	_ = l.Write(ctx, "foo/bar/baz.json", []byte(`{}`))
+	_ = l.Write(ctx, "foo/qux.json", []byte(`{}`))
+	_ = l.Write(ctx, "foo/qux.json", []byte(`{"v":2}`))
+	if dirs, _ := l.ListTypes(ctx, "foo", fs.ModeDir); !reflect.DeepEqual(dirs, []string{"bar"}) {
+		t.Errorf("ListTypes(foo, dir) got = %v, want [bar]", dirs)
+	}
+	if files, _ := l.ListTypes(ctx, "foo", 0); !reflect.DeepEqual(files, []string{"qux.json"}) {
+		t.Errorf("ListTypes(foo, file) got = %v, want [qux.json]", files)
+	}
+	_ = l.Delete(ctx, "foo/bar/baz.json")
+	if dirs, _ := l.ListTypes(ctx, "foo", fs.ModeDir); len(dirs) != 0 {
+		t.Errorf("ListTypes(foo, dir) after Delete() got = %v, want none", dirs)
+	}
+	if _, err := l.ListTypes(ctx, "foo/bar", 0); !goos.IsNotExist(err) {
+		t.Errorf("ListTypes(foo/bar) after Delete() error = %v, want not exist", err)
+	}
+}
@@ -2,34 +2,96 @@ package fs
 
 import (
 	"context"
+	"fmt"
+	"github.com/skroczek/go-simple-json-store/backend"
 	"github.com/skroczek/go-simple-json-store/errors"
+	"io/fs"
+	"log"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
 type Blob struct {
-	Content []byte
-	ModTime time.Time
+	Content    []byte
+	ModTime    time.Time
+	CreateTime time.Time
+	Metadata   map[string]string
 }
 
+// Memory is an in-memory backend. It is safe for concurrent use. Optionally it persists itself as a snapshot file,
+// see WithSnapshotFile and WithSnapshotInterval.
 type Memory struct {
-	tree map[string]interface{}
+	mu               sync.RWMutex
+	tree             map[string]interface{}
+	snapshotFile     string
+	snapshotInterval time.Duration
+	deleteEmptyDirs  bool
+	events           backend.EventHub
+	stop             chan struct{}
+	done             chan struct{}
 }
 
-func NewMemory() *Memory {
-	return &Memory{
+type MemoryOption func(*Memory)
+
+// WithSnapshotFile restores the memory backend from the given file on creation and writes a snapshot to it on Close.
+func WithSnapshotFile(path string) MemoryOption {
+	return func(m *Memory) {
+		m.snapshotFile = path
+	}
+}
+
+// WithSnapshotInterval additionally writes a snapshot periodically. It has no effect without WithSnapshotFile.
+func WithSnapshotInterval(interval time.Duration) MemoryOption {
+	return func(m *Memory) {
+		m.snapshotInterval = interval
+	}
+}
+
+// WithMemoryDeleteEmptyDirs removes directories that became empty when their last document was deleted and allows to
+// delete empty directories, like WithDeleteEmptyDirs does for the file system backend.
+func WithMemoryDeleteEmptyDirs() MemoryOption {
+	return func(m *Memory) {
+		m.deleteEmptyDirs = true
+	}
+}
+
+// NewMemory returns a Memory backend. It panics if the snapshot file cannot be restored, use OpenMemory to handle
+// that error.
+func NewMemory(options ...MemoryOption) *Memory {
+	m, err := OpenMemory(options...)
+	if err != nil {
+		log.Panicf("Error: %+v", err)
+	}
+	return m
+}
+
+// OpenMemory returns a Memory backend, or an error if the snapshot file given by WithSnapshotFile cannot be restored
+func OpenMemory(options ...MemoryOption) (*Memory, error) {
+	m := &Memory{
 		tree: make(map[string]interface{}),
 	}
+	for _, option := range options {
+		option(m)
+	}
+	if m.snapshotFile != "" {
+		if err := m.Restore(); err != nil {
+			return nil, fmt.Errorf("unable to restore memory backend from %s: %w", m.snapshotFile, err)
+		}
+		if m.snapshotInterval > 0 {
+			m.stop = make(chan struct{})
+			m.done = make(chan struct{})
+			go m.snapshotLoop()
+		}
+	}
+	return m, nil
 }
 
 func (m *Memory) getBlob(path string) (*Blob, error) {
-	path = strings.Trim(path, "/")
-	if len(path) < 6 {
-		return nil, errors.ErrorInvalidPath
-	}
-	if !strings.HasSuffix(path, ".json") {
-		return nil, errors.ErrorMissingExtension
+	path, err := documentPath(path)
+	if err != nil {
+		return nil, err
 	}
 	parts := strings.Split(path, "/")
 	tree := m.tree
@@ -46,12 +108,11 @@ func (m *Memory) getBlob(path string) (*Blob, error) {
 }
 
 func (m *Memory) Exists(ctx context.Context, path string) (bool, error) {
-	path = strings.Trim(path, "/")
-	if len(path) < 6 {
-		return false, errors.ErrorInvalidPath
-	}
-	if !strings.HasSuffix(path, ".json") {
-		return false, errors.ErrorMissingExtension
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	path, err := documentPath(path)
+	if err != nil {
+		return false, err
 	}
 	blob, err := m.getBlob(path)
 	if err != nil {
@@ -61,6 +122,8 @@ func (m *Memory) Exists(ctx context.Context, path string) (bool, error) {
 }
 
 func (m *Memory) Get(ctx context.Context, path string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	blob, err := m.getBlob(path)
 	if err != nil {
 		return nil, err
@@ -69,13 +132,22 @@ func (m *Memory) Get(ctx context.Context, path string) ([]byte, error) {
 }
 
 func (m *Memory) Write(ctx context.Context, path string, data []byte) error {
-	path = strings.TrimLeft(path, "/")
-	if len(path) < 6 {
-		return errors.ErrorInvalidPath
-	}
-	if !strings.HasSuffix(path, ".json") {
+	if strings.HasSuffix(path, "/") {
 		return errors.ErrorMissingExtension
 	}
+	path, err := documentPath(path)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.write(path, data)
+	return nil
+}
+
+// write stores data at the trimmed and validated path and publishes the change. It must be called with the write lock
+// held.
+func (m *Memory) write(path string, data []byte) {
 	parts := strings.Split(path, "/")
 	tree := m.tree
 	var ok bool
@@ -87,42 +159,98 @@ func (m *Memory) Write(ctx context.Context, path string, data []byte) error {
 			tree, _ = tree[parts[i]].(map[string]interface{})
 		}
 	}
-	tree[parts[len(parts)-1]] = &Blob{Content: data, ModTime: time.Now()}
-	return nil
+	now := time.Now()
+	blob := &Blob{Content: data, ModTime: now, CreateTime: now}
+	event := backend.Event{Type: backend.EventCreate, Path: "/" + path, ModTime: now}
+	if previous, ok := tree[parts[len(parts)-1]].(*Blob); ok {
+		blob.Metadata = previous.Metadata
+		if !previous.CreateTime.IsZero() {
+			blob.CreateTime = previous.CreateTime
+		}
+		event.Type = backend.EventUpdate
+	}
+	tree[parts[len(parts)-1]] = blob
+	m.events.Publish(event)
 }
 
 func (m *Memory) Delete(ctx context.Context, path string) error {
-	path = strings.Trim(path, "/")
+	path, err := directoryPath(path)
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		return errors.ErrorInvalidPath
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.delete(path)
+}
+
+// delete removes the document or directory at the trimmed path. Directories are only removed with
+// WithMemoryDeleteEmptyDirs and only if they are empty, otherwise a DeleteDirectoryError is returned. It must be called
+// with the write lock held.
+func (m *Memory) delete(path string) error {
 	parts := strings.Split(path, "/")
-	tree := m.tree
-	var ok bool
+	trees := []map[string]interface{}{m.tree}
 	for i := 0; i < (len(parts) - 1); i++ {
-		if tree, ok = tree[parts[i]].(map[string]interface{}); !ok {
+		tree, ok := trees[i][parts[i]].(map[string]interface{})
+		if !ok {
 			return os.ErrNotExist
 		}
+		trees = append(trees, tree)
 	}
-	if _, ok := tree[parts[len(parts)-1]].(*Blob); ok {
-		delete(tree, parts[len(parts)-1])
-		return nil
+	tree, name := trees[len(trees)-1], parts[len(parts)-1]
+	node := tree[name]
+	switch dir := node.(type) {
+	case *Blob:
+	case map[string]interface{}:
+		if !m.deleteEmptyDirs {
+			return NewDeleteDirectoryError(path)
+		}
+		if len(dir) > 0 {
+			return NewDeleteDirectoryError(path)
+		}
+	default:
+		return os.ErrNotExist
+	}
+	delete(tree, name)
+	if _, ok := node.(*Blob); ok {
+		m.events.Publish(backend.Event{Type: backend.EventDelete, Path: "/" + path, ModTime: time.Now()})
+	}
+	if m.deleteEmptyDirs {
+		for i := len(trees) - 1; i > 0 && len(trees[i]) == 0; i-- {
+			delete(trees[i-1], parts[i-1])
+		}
 	}
-	return os.ErrNotExist
+	return nil
 }
 
-func (m *Memory) List(ctx context.Context, path string) ([]string, error) {
-	path = strings.Trim(path, "/")
+// lookupDir returns the directory at the trimmed path. It must be called with the lock held.
+func (m *Memory) lookupDir(path string) (map[string]interface{}, error) {
 	tree := m.tree
-	if path != "" {
-		parts := strings.Split(path, "/")
+	if path == "" {
+		return tree, nil
+	}
+	for _, part := range strings.Split(path, "/") {
 		var ok bool
-		for i := 0; i < (len(parts) - 1); i++ {
-			if tree, ok = tree[parts[i]].(map[string]interface{}); !ok {
-				return nil, os.ErrNotExist
-			}
-		}
-		if tree, ok = tree[parts[len(parts)-1]].(map[string]interface{}); !ok {
+		if tree, ok = tree[part].(map[string]interface{}); !ok {
 			return nil, os.ErrNotExist
 		}
 	}
+	return tree, nil
+}
+
+func (m *Memory) List(ctx context.Context, path string) ([]string, error) {
+	path, err := directoryPath(path)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	tree, err := m.lookupDir(path)
+	if err != nil {
+		return nil, err
+	}
 	var result []string
 	for k, v := range tree {
 		if _, ok := v.(*Blob); ok {
@@ -132,10 +260,111 @@ func (m *Memory) List(ctx context.Context, path string) ([]string, error) {
 	return result, nil
 }
 
+// ListTypes returns the names of the directories for fs.ModeDir and of the documents for 0, see backend.FileBackend
+func (m *Memory) ListTypes(ctx context.Context, path string, mode fs.FileMode) ([]string, error) {
+	path, err := directoryPath(path)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	tree, err := m.lookupDir(path)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0)
+	for k, v := range tree {
+		switch v.(type) {
+		case *Blob:
+			if mode == 0 {
+				result = append(result, k)
+			}
+		case map[string]interface{}:
+			if mode == fs.ModeDir {
+				result = append(result, k)
+			}
+		}
+	}
+	return result, nil
+}
+
 func (m *Memory) GetLastModified(ctx context.Context, path string) (time.Time, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	blob, err := m.getBlob(path)
 	if err != nil {
 		return time.Time{}, err
 	}
 	return blob.ModTime, nil
 }
+
+func (m *Memory) GetWithRevision(ctx context.Context, path string) ([]byte, string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	blob, err := m.getBlob(path)
+	if err != nil {
+		return nil, "", err
+	}
+	return blob.Content, backend.ContentRevision(blob.Content), nil
+}
+
+func (m *Memory) WriteIfRevision(ctx context.Context, path string, data []byte, revision string) error {
+	path, err := documentPath(path)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	blob, err := m.getBlob(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if (err == nil) != (revision != "") || (err == nil && backend.ContentRevision(blob.Content) != revision) {
+		return errors.ErrorRevisionMismatch
+	}
+	m.write(path, data)
+	return nil
+}
+
+func (m *Memory) Stat(ctx context.Context, path string) (backend.DocumentInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	blob, err := m.getBlob(path)
+	if err != nil {
+		return backend.DocumentInfo{}, err
+	}
+	info := backend.DocumentInfo{
+		Size:       int64(len(blob.Content)),
+		ModTime:    blob.ModTime,
+		CreateTime: blob.CreateTime,
+		Hash:       backend.ContentRevision(blob.Content),
+	}
+	if len(blob.Metadata) > 0 {
+		info.Metadata = make(map[string]string, len(blob.Metadata))
+		for key, value := range blob.Metadata {
+			info.Metadata[key] = value
+		}
+	}
+	return info, nil
+}
+
+// SetMetadata replaces the user metadata of a document. The metadata is kept when the document is written again.
+func (m *Memory) SetMetadata(ctx context.Context, path string, metadata map[string]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	blob, err := m.getBlob(path)
+	if err != nil {
+		return err
+	}
+	copied := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		copied[key] = value
+	}
+	blob.Metadata = copied
+	return nil
+}
+
+// Watch reports the changes of documents below prefix, see backend.Watcher
+func (m *Memory) Watch(ctx context.Context, prefix string) (<-chan backend.Event, error) {
+	return m.events.Watch(ctx, prefix)
+}
@@ -0,0 +1,128 @@
+package fs
+
+import (
+	"encoding/json"
+	"log"
+	goos "os"
+	"strings"
+	"time"
+)
+
+const snapshotVersion = 1
+
+type snapshot struct {
+	Version   int                      `json:"version"`
+	Documents map[string]snapshotEntry `json:"documents"`
+}
+
+type snapshotEntry struct {
+	Content    []byte            `json:"content"`
+	ModTime    time.Time         `json:"modTime"`
+	CreateTime time.Time         `json:"createTime"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+// Snapshot writes the current content of the memory backend to the snapshot file. The file is replaced atomically, so
+// an interrupted snapshot never destroys the previous one.
+func (m *Memory) Snapshot() error {
+	if m.snapshotFile == "" {
+		return nil
+	}
+	s := snapshot{Version: snapshotVersion, Documents: make(map[string]snapshotEntry)}
+	m.mu.RLock()
+	flatten(m.tree, "", s.Documents)
+	m.mu.RUnlock()
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(m.snapshotFile, data, 0600)
+}
+
+// Restore replaces the content of the memory backend with the content of the snapshot file. A missing snapshot file
+// is not an error.
+func (m *Memory) Restore() error {
+	if m.snapshotFile == "" {
+		return nil
+	}
+	data, err := goos.ReadFile(m.snapshotFile)
+	if err != nil {
+		if goos.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var s snapshot
+	if err = json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	tree := make(map[string]interface{})
+	for path, entry := range s.Documents {
+		if entry.CreateTime.IsZero() {
+			// snapshots written before creation times were tracked
+			entry.CreateTime = entry.ModTime
+		}
+		parts := strings.Split(path, "/")
+		t := tree
+		for i := 0; i < (len(parts) - 1); i++ {
+			sub, ok := t[parts[i]].(map[string]interface{})
+			if !ok {
+				sub = make(map[string]interface{})
+				t[parts[i]] = sub
+			}
+			t = sub
+		}
+		t[parts[len(parts)-1]] = &Blob{
+			Content:    entry.Content,
+			ModTime:    entry.ModTime,
+			CreateTime: entry.CreateTime,
+			Metadata:   entry.Metadata,
+		}
+	}
+	m.mu.Lock()
+	m.tree = tree
+	m.mu.Unlock()
+	return nil
+}
+
+// Close stops the periodic snapshots and writes a final snapshot. It should be called on shutdown.
+func (m *Memory) Close() error {
+	if m.stop != nil {
+		close(m.stop)
+		<-m.done
+		m.stop = nil
+	}
+	return m.Snapshot()
+}
+
+func (m *Memory) snapshotLoop() {
+	defer close(m.done)
+	ticker := time.NewTicker(m.snapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.Snapshot(); err != nil {
+				log.Printf("unable to write snapshot %s: %v", m.snapshotFile, err)
+			}
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func flatten(tree map[string]interface{}, prefix string, documents map[string]snapshotEntry) {
+	for name, node := range tree {
+		switch n := node.(type) {
+		case *Blob:
+			documents[prefix+name] = snapshotEntry{
+				Content:    n.Content,
+				ModTime:    n.ModTime,
+				CreateTime: n.CreateTime,
+				Metadata:   n.Metadata,
+			}
+		case map[string]interface{}:
+			flatten(n, prefix+name+"/", documents)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package fs
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/skroczek/go-simple-json-store/backend"
+)
+
+func validateDocumentPath(path string) error {
+	_, err := documentPath(path)
+	return err
+}
+
+// Begin starts a transaction. Commit holds the write lock while it applies the operations, so other readers and
+// writers see either none or all of them.
+func (m *Memory) Begin(ctx context.Context) (backend.Transaction, error) {
+	return backend.NewStagedTransaction(validateDocumentPath, m.commit), nil
+}
+
+func (m *Memory) commit(ctx context.Context, ops []backend.TransactionOp) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, op := range ops {
+		if op.Delete {
+			if _, err := m.getBlob(op.Path); err != nil {
+				return err
+			}
+		}
+	}
+	for _, op := range ops {
+		path := strings.Trim(op.Path, "/")
+		if op.Delete {
+			if err := m.delete(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		m.write(path, op.Data)
+	}
+	return nil
+}
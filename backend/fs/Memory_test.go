@@ -4,9 +4,13 @@ import (
 	"context"
 	"fmt"
 	"github.com/skroczek/go-simple-json-store/backend"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"reflect"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -747,3 +751,95 @@ func TestNewMemory(t *testing.T) {
 		})
 	}
 }
+
+func TestMemory_Concurrent(t *testing.T) {
+	m := NewMemory()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			path := fmt.Sprintf("foo/%d.json", i)
+			for j := 0; j < 100; j++ {
+				_ = m.Write(context.TODO(), path, []byte("{}"))
+				_, _ = m.Get(context.TODO(), path)
+				_, _ = m.List(context.TODO(), "foo")
+				_ = m.Delete(context.TODO(), path)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestMemory_SnapshotRestore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "snapshot.json")
+	m := NewMemory(WithSnapshotFile(file))
+	if err := m.Write(context.TODO(), "foo/bar/baz.json", []byte("test1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Write(context.TODO(), "foo.json", []byte("test2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	restored := NewMemory(WithSnapshotFile(file))
+	for path, want := range map[string]string{"foo/bar/baz.json": "test1", "foo.json": "test2"} {
+		got, err := restored.Get(context.TODO(), path)
+		if err != nil {
+			t.Errorf("Get(%s) error = %v", path, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("Get(%s) got = %s, want %s", path, got, want)
+		}
+		wantTime, _ := m.GetLastModified(context.TODO(), path)
+		gotTime, _ := restored.GetLastModified(context.TODO(), path)
+		if !gotTime.Equal(wantTime) {
+			t.Errorf("GetLastModified(%s) got = %v, want %v", path, gotTime, wantTime)
+		}
+	}
+}
+
+func TestOpenMemory_CorruptSnapshot(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := os.WriteFile(file, []byte("{corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := OpenMemory(WithSnapshotFile(file)); err == nil || m != nil {
+		t.Errorf("OpenMemory() = %v, %v, want error", m, err)
+	}
+}
+
+func TestMemory_DeleteDirectory(t *testing.T) {
+	ctx := context.TODO()
+	m := NewMemory()
+	_ = m.Write(ctx, "/foo/bar/baz.json", []byte("{}"))
+	if err := m.Delete(ctx, "/foo/bar"); err == nil {
+		t.Errorf("Delete() of directory without WithMemoryDeleteEmptyDirs error = nil")
+	}
+	_ = m.Delete(ctx, "/foo/bar/baz.json")
+	if dirs, _ := m.ListTypes(ctx, "/foo", fs.ModeDir); !reflect.DeepEqual(dirs, []string{"bar"}) {
+		t.Errorf("ListTypes() after Delete() = %v, want [bar]", dirs)
+	}
+
+	m = NewMemory(WithMemoryDeleteEmptyDirs())
+	_ = m.Write(ctx, "/foo/bar/baz.json", []byte("{}"))
+	_ = m.Write(ctx, "/foo/qux.json", []byte("{}"))
+	if err := m.Delete(ctx, "/foo/bar/baz.json"); err != nil {
+		t.Fatal(err)
+	}
+	if dirs, _ := m.ListTypes(ctx, "/foo", fs.ModeDir); len(dirs) != 0 {
+		t.Errorf("ListTypes() after Delete() = %v, want no directories", dirs)
+	}
+	if _, ok := m.Delete(ctx, "/foo").(*DeleteDirectoryError); !ok {
+		t.Errorf("Delete() of directory that is not empty did not fail with DeleteDirectoryError")
+	}
+	if ok, _ := m.Exists(ctx, "/foo/qux.json"); !ok {
+		t.Errorf("Delete() of directory removed its document")
+	}
+	_ = m.Delete(ctx, "/foo/qux.json")
+	if dirs, _ := m.ListTypes(ctx, "/", fs.ModeDir); len(dirs) != 0 {
+		t.Errorf("ListTypes(/) after deleting the last document = %v, want no directories", dirs)
+	}
+}
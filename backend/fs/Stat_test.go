@@ -0,0 +1,103 @@
+package fs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/skroczek/go-simple-json-store/backend"
+)
+
+type metadataBackend interface {
+	backend.Stater
+	SetMetadata(ctx context.Context, path string, metadata map[string]string) error
+}
+
+func testStat(t *testing.T, be metadataBackend) {
+	ctx := context.TODO()
+	if _, err := be.Stat(ctx, "/missing.json"); !os.IsNotExist(err) {
+		t.Errorf("Stat() of missing document error = %v, want not exist", err)
+	}
+	if err := be.Write(ctx, "/foo.json", []byte(`{"a":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	info, err := be.Stat(ctx, "/foo.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 7 || info.Hash != backend.ContentRevision([]byte(`{"a":1}`)) {
+		t.Errorf("Stat() = %+v", info)
+	}
+	if info.ModTime.IsZero() || info.CreateTime.IsZero() {
+		t.Errorf("Stat() times = %v, %v", info.ModTime, info.CreateTime)
+	}
+
+	err = be.SetMetadata(ctx, "/foo.json", map[string]string{"owner": "alice"})
+	if err == errUnsupported {
+		t.Skip("file system does not support extended attributes")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err = be.Write(ctx, "/foo.json", []byte(`{"a":2}`)); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := be.Stat(ctx, "/foo.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated.CreateTime.Equal(info.CreateTime) {
+		t.Errorf("CreateTime after write = %v, want %v", updated.CreateTime, info.CreateTime)
+	}
+	if !updated.ModTime.After(info.ModTime) {
+		t.Errorf("ModTime after write = %v, want after %v", updated.ModTime, info.ModTime)
+	}
+	if updated.Metadata["owner"] != "alice" || len(updated.Metadata) != 1 {
+		t.Errorf("Metadata after write = %v", updated.Metadata)
+	}
+}
+
+func TestMemory_Stat(t *testing.T) {
+	testStat(t, NewMemory())
+}
+
+func TestFilesystemBackend_Stat(t *testing.T) {
+	testStat(t, NewFilesystemBackend(t.TempDir()))
+}
+
+func TestFilesystemBackend_Stat_StoredHash(t *testing.T) {
+	root := t.TempDir()
+	f := NewFilesystemBackend(root)
+	ctx := context.TODO()
+	if err := f.Write(ctx, "/foo.json", []byte(`{"a":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	fullPath := filepath.Join(root, "foo.json")
+	attributes, err := readAttributes(fullPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := attributes[hashAttribute]; !ok {
+		t.Skip("file system does not support extended attributes")
+	}
+	// a stored hash is taken as is, the file is not read
+	info, _ := os.Stat(fullPath)
+	attributes[hashAttribute] = hashValue(info.ModTime(), "stored")
+	if err = writeAttributes(fullPath, attributes); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := f.Stat(ctx, "/foo.json"); info.Hash != "stored" {
+		t.Errorf("Stat() hash = %s, want the stored hash", info.Hash)
+	}
+	// a file changed in place by another program is hashed again
+	if err = os.WriteFile(fullPath, []byte(`{"a":2}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Chtimes(fullPath, time.Now(), info.ModTime().Add(time.Second))
+	if info, _ := f.Stat(ctx, "/foo.json"); info.Hash != backend.ContentRevision([]byte(`{"a":2}`)) {
+		t.Errorf("Stat() hash after external change = %s", info.Hash)
+	}
+}
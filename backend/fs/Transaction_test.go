@@ -0,0 +1,158 @@
+package fs
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	goos "os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skroczek/go-simple-json-store/backend"
+	"github.com/skroczek/go-simple-json-store/errors"
+)
+
+func testTransaction(t *testing.T, be backend.Transactional) {
+	ctx := context.TODO()
+	_ = be.Write(ctx, "/index.json", []byte(`[]`))
+	_ = be.Write(ctx, "/old.json", []byte(`{}`))
+
+	tx, _ := be.Begin(ctx)
+	_ = tx.Write("/index.json", []byte(`["a"]`))
+	_ = tx.Write("/docs/a.json", []byte(`{"a":1}`))
+	_ = tx.Delete("/missing.json")
+	if err := tx.Commit(ctx); !goos.IsNotExist(err) {
+		t.Errorf("Commit() with missing document error = %v", err)
+	}
+	if data, _ := be.Get(ctx, "/index.json"); string(data) != `[]` {
+		t.Errorf("failed Commit() applied write: %s", data)
+	}
+	if ok, _ := be.Exists(ctx, "/docs/a.json"); ok {
+		t.Errorf("failed Commit() created document")
+	}
+
+	tx, _ = be.Begin(ctx)
+	_ = tx.Write("/index.json", []byte(`["a"]`))
+	_ = tx.Write("/docs/a.json", []byte(`{"a":1}`))
+	_ = tx.Delete("/old.json")
+	if err := tx.Write("/docs/a", nil); err == nil {
+		t.Errorf("Write() with invalid path succeeded")
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if err := tx.Commit(ctx); err != backend.ErrTransactionClosed {
+		t.Errorf("second Commit() error = %v", err)
+	}
+	for path, want := range map[string]string{"/index.json": `["a"]`, "/docs/a.json": `{"a":1}`} {
+		if data, err := be.Get(ctx, path); err != nil || string(data) != want {
+			t.Errorf("Get(%s) after Commit() got = %s, %v", path, data, err)
+		}
+	}
+	if ok, _ := be.Exists(ctx, "/old.json"); ok {
+		t.Errorf("Commit() did not delete /old.json")
+	}
+
+	tx, _ = be.Begin(ctx)
+	_ = tx.Write("/index.json", []byte(`["b"]`))
+	_ = tx.Rollback()
+	if data, _ := be.Get(ctx, "/index.json"); string(data) != `["a"]` {
+		t.Errorf("Rollback() applied write: %s", data)
+	}
+}
+
+func TestMemory_Transaction(t *testing.T) {
+	testTransaction(t, NewMemory())
+}
+
+func TestFilesystemBackend_Transaction(t *testing.T) {
+	root := t.TempDir()
+	testTransaction(t, NewFilesystemBackend(root, WithCreateDirs()))
+	if entries, _ := goos.ReadDir(filepath.Join(root, journalDir)); len(entries) != 0 {
+		t.Errorf("Commit() left %d journal files", len(entries))
+	}
+}
+
+func TestFilesystemBackend_TransactionApplyFails(t *testing.T) {
+	root := t.TempDir()
+	f := NewFilesystemBackend(root, WithCreateDirs())
+	ctx := context.TODO()
+	_ = f.Write(ctx, "/index.json", []byte(`[]`))
+	_ = f.Write(ctx, "/old.json", []byte(`{}`))
+	// a file in place of the directory makes the write of /docs/a.json fail after the validation
+	_ = goos.WriteFile(filepath.Join(root, "docs"), nil, 0644)
+
+	tx, _ := f.Begin(ctx)
+	_ = tx.Write("/index.json", []byte(`["a"]`))
+	_ = tx.Delete("/old.json")
+	_ = tx.Write("/docs/a.json", []byte(`{"a":1}`))
+	if err := tx.Commit(ctx); err == nil {
+		t.Fatalf("Commit() succeeded")
+	}
+	if data, _ := f.Get(ctx, "/index.json"); string(data) != `[]` {
+		t.Errorf("failed Commit() left /index.json = %s", data)
+	}
+	if data, _ := f.Get(ctx, "/old.json"); string(data) != `{}` {
+		t.Errorf("failed Commit() left /old.json = %s", data)
+	}
+	if entries, _ := goos.ReadDir(filepath.Join(root, journalDir)); len(entries) != 0 {
+		t.Errorf("Commit() left %d journal files", len(entries))
+	}
+}
+
+func TestFilesystemBackend_RecoverJournal(t *testing.T) {
+	root := t.TempDir()
+	// the crash happened after /docs/a.json and /b.json were written, /b.json was changed again after the restart
+	// and /old.json was not deleted yet
+	_ = goos.MkdirAll(filepath.Join(root, "docs"), 0755)
+	_ = goos.WriteFile(filepath.Join(root, "docs", "a.json"), []byte(`{"a":1}`), 0644)
+	_ = goos.WriteFile(filepath.Join(root, "b.json"), []byte(`{"b":3}`), 0644)
+	_ = goos.WriteFile(filepath.Join(root, "old.json"), []byte(`{}`), 0644)
+	data, _ := json.Marshal(journal{Undo: []undoEntry{
+		{Path: "/docs/a.json", Revision: backend.ContentRevision([]byte(`{"a":1}`))},
+		{Path: "/b.json", Existed: true, Data: []byte(`{"b":1}`), Revision: backend.ContentRevision([]byte(`{"b":2}`))},
+		{Path: "/old.json", Existed: true, Data: []byte(`{}`)},
+	}})
+	_ = goos.MkdirAll(filepath.Join(root, journalDir), 0700)
+	_ = goos.WriteFile(filepath.Join(root, journalDir, "1.json"), data, 0600)
+
+	f := NewFilesystemBackend(root, WithCreateDirs())
+	if ok, _ := f.Exists(context.TODO(), "/docs/a.json"); ok {
+		t.Errorf("recovery did not delete /docs/a.json")
+	}
+	if data, _ := f.Get(context.TODO(), "/b.json"); string(data) != `{"b":3}` {
+		t.Errorf("recovery overwrote newer /b.json with %s", data)
+	}
+	if data, _ := f.Get(context.TODO(), "/old.json"); string(data) != `{}` {
+		t.Errorf("recovery changed /old.json to %s", data)
+	}
+	if entries, _ := goos.ReadDir(filepath.Join(root, journalDir)); len(entries) != 0 {
+		t.Errorf("recovery left %d journal files", len(entries))
+	}
+	if list, _ := f.ListTypes(context.TODO(), "/", fs.ModeDir); len(list) != 1 {
+		t.Errorf("ListTypes() got = %v, journal directory must be hidden", list)
+	}
+}
+
+func TestFilesystemBackend_JournalNotWritable(t *testing.T) {
+	root := t.TempDir()
+	f := NewFilesystemBackend(root, WithCreateDirs())
+	ctx := context.TODO()
+	_ = f.Write(ctx, "/victim.json", []byte(`{}`))
+	// a document in the journal directory would be replayed as journal on the next start
+	data, _ := json.Marshal(journal{Undo: []undoEntry{{Path: "/victim.json", Revision: backend.ContentRevision([]byte(`{}`))}}})
+	path := "/" + journalDir + "/1.json"
+	if err := f.Write(ctx, path, data); err != errors.ErrorInvalidPath {
+		t.Errorf("Write(%s) error = %v, want %v", path, err, errors.ErrorInvalidPath)
+	}
+	tx, _ := f.Begin(ctx)
+	if err := tx.Write(path, data); err != errors.ErrorInvalidPath {
+		t.Errorf("Transaction Write(%s) error = %v, want %v", path, err, errors.ErrorInvalidPath)
+	}
+	_ = tx.Rollback()
+
+	f = NewFilesystemBackend(root, WithCreateDirs())
+	if ok, _ := f.Exists(ctx, "/victim.json"); !ok {
+		t.Errorf("recovery deleted /victim.json")
+	}
+}
@@ -0,0 +1,102 @@
+package fs
+
+import (
+	"context"
+	"io/fs"
+	goos "os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/skroczek/go-simple-json-store/backend"
+	"github.com/skroczek/go-simple-json-store/errors"
+)
+
+// Walk calls fn for every document below prefix, see backend.Walker. The documents are collected first, so fn may
+// use the backend.
+func (m *Memory) Walk(ctx context.Context, prefix string, fn backend.WalkFunc) error {
+	prefix, err := directoryPath(prefix)
+	if err != nil {
+		return err
+	}
+	m.mu.RLock()
+	tree, err := m.lookupDir(prefix)
+	if err != nil {
+		m.mu.RUnlock()
+		return err
+	}
+	if prefix != "" {
+		prefix = "/" + prefix
+	}
+	var paths []string
+	collectPaths(tree, prefix, &paths)
+	m.mu.RUnlock()
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func collectPaths(tree map[string]interface{}, dir string, paths *[]string) {
+	names := make([]string, 0, len(tree))
+	for name := range tree {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		switch node := tree[name].(type) {
+		case *Blob:
+			*paths = append(*paths, dir+"/"+name)
+		case map[string]interface{}:
+			collectPaths(node, dir+"/"+name, paths)
+		}
+	}
+}
+
+// Walk calls fn for every document below prefix, see backend.Walker. Internal files and files without the .json
+// extension are skipped.
+func (f FilesystemBackend) Walk(ctx context.Context, prefix string, fn backend.WalkFunc) error {
+	prefix, err := directoryPath(prefix)
+	if err != nil {
+		return err
+	}
+	base := filepath.Clean(f.Root)
+	root := filepath.Join(base, prefix)
+	if rel, err := filepath.Rel(base, root); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return errors.ErrorInvalidPath
+	}
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path != root && goos.IsNotExist(err) {
+				// deleted while walking
+				return nil
+			}
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		if isInternal(d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !d.Type().IsRegular() || filepath.Ext(d.Name()) != ".json" {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		return fn("/" + filepath.ToSlash(rel))
+	})
+}
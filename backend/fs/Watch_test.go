@@ -0,0 +1,67 @@
+package fs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/skroczek/go-simple-json-store/backend"
+)
+
+func nextEvent(t *testing.T, events <-chan backend.Event) backend.Event {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+		return backend.Event{}
+	}
+}
+
+func testWatch(t *testing.T, be backend.Watcher) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	events, err := be.Watch(ctx, "/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = be.Write(ctx, "/other.json", []byte("{}"))
+	_ = be.Write(ctx, "/foo/bar.json", []byte("1"))
+	_ = be.Write(ctx, "/foo/bar.json", []byte("2"))
+	_ = be.Delete(ctx, "/foo/bar.json")
+	for _, want := range []backend.EventType{backend.EventCreate, backend.EventUpdate, backend.EventDelete} {
+		e := nextEvent(t, events)
+		if e.Type != want || e.Path != "/foo/bar.json" || e.ModTime.IsZero() {
+			t.Errorf("event = %+v, want %s of /foo/bar.json", e, want)
+		}
+	}
+
+	if transactional, ok := be.(backend.Transactional); ok {
+		tx, _ := transactional.Begin(ctx)
+		_ = tx.Write("/foo/baz.json", []byte("{}"))
+		if err := tx.Commit(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if e := nextEvent(t, events); e.Type != backend.EventCreate || e.Path != "/foo/baz.json" {
+			t.Errorf("event of transaction = %+v", e)
+		}
+	}
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Errorf("unexpected event after cancel")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("channel not closed after cancel")
+	}
+}
+
+func TestMemory_Watch(t *testing.T) {
+	testWatch(t, NewMemory())
+}
+
+func TestFilesystemBackend_Watch(t *testing.T) {
+	testWatch(t, NewFilesystemBackend(t.TempDir(), WithCreateDirs()))
+}
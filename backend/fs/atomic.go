@@ -0,0 +1,99 @@
+package fs
+
+import (
+	"io/fs"
+	"log"
+	goos "os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// internalPrefix marks files and directories the backend keeps for itself. They are never reported by List or
+// ListTypes.
+const internalPrefix = ".gsjs-"
+
+// tempPrefix is the name prefix of temporary files created during atomic writes
+const tempPrefix = internalPrefix + "tmp-"
+
+func isInternal(name string) bool {
+	return strings.HasPrefix(name, internalPrefix)
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory as name, syncs it to disk and renames it
+// over name. The directory is synced afterwards, so the rename survives a crash. Readers either see the old or the
+// new content, never a partially written file.
+func writeFileAtomic(name string, data []byte, perm fs.FileMode) error {
+	return writeFileAtomicWith(name, data, perm, nil)
+}
+
+// writeFileAtomicWith is writeFileAtomic, but calls prepare with the temporary file before it is renamed
+func writeFileAtomicWith(name string, data []byte, perm fs.FileMode, prepare func(tmp *goos.File) error) (err error) {
+	dir := filepath.Dir(name)
+	tmp, err := goos.CreateTemp(dir, tempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = goos.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(perm); err != nil {
+		return err
+	}
+	if prepare != nil {
+		if err = prepare(tmp); err != nil {
+			return err
+		}
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = goos.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir flushes the directory entry changes of dir to disk
+func syncDir(dir string) error {
+	d, err := goos.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err = d.Sync(); err != nil {
+		_ = d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// removeTempFiles removes temporary files left behind by writes that were interrupted by a crash. Files modified
+// after notBefore are kept, they may belong to a write of another process that is still running.
+func removeTempFiles(root string, notBefore time.Time) {
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasPrefix(d.Name(), tempPrefix) {
+			if info, err := d.Info(); err != nil || info.ModTime().After(notBefore) {
+				return nil
+			}
+			if err := goos.Remove(path); err != nil {
+				log.Printf("unable to remove temporary file %s: %v", path, err)
+			}
+		}
+		return nil
+	})
+	if err != nil && !goos.IsNotExist(err) {
+		log.Printf("unable to clean up temporary files in %s: %v", root, err)
+	}
+}
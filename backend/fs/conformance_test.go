@@ -0,0 +1,43 @@
+package fs
+
+import (
+	"testing"
+
+	"github.com/skroczek/go-simple-json-store/backend"
+	"github.com/skroczek/go-simple-json-store/backend/backendtest"
+)
+
+func TestMemory_Conformance(t *testing.T) {
+	backendtest.RunConformance(t, func(t *testing.T) backend.Backend {
+		return NewMemory()
+	})
+}
+
+func TestFilesystemBackend_Conformance(t *testing.T) {
+	backendtest.RunConformance(t, func(t *testing.T) backend.Backend {
+		return NewFilesystemBackend(t.TempDir(), WithCreateDirs())
+	})
+}
+
+func TestFilesystemBackend_ConformanceDeleteEmptyDirs(t *testing.T) {
+	backendtest.RunConformance(t, func(t *testing.T) backend.Backend {
+		return NewFilesystemBackend(t.TempDir(), WithCreateDirs(), WithDeleteEmptyDirs())
+	})
+}
+
+func TestLogBackend_Conformance(t *testing.T) {
+	backendtest.RunConformance(t, func(t *testing.T) backend.Backend {
+		l, err := NewLogBackend(t.TempDir(), WithCompactionInterval(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = l.Close() })
+		return l
+	})
+}
+
+func TestMemory_ConformanceDeleteEmptyDirs(t *testing.T) {
+	backendtest.RunConformance(t, func(t *testing.T) backend.Backend {
+		return NewMemory(WithMemoryDeleteEmptyDirs())
+	})
+}
@@ -0,0 +1,145 @@
+package fs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	goos "os"
+	"path/filepath"
+	"time"
+
+	"github.com/skroczek/go-simple-json-store/errors"
+)
+
+// lockDir is the directory below the root that holds the lock files of WithFileLocking
+const lockDir = internalPrefix + "locks"
+
+// lockPollInterval is how often a lock held by another process is tried again
+const lockPollInterval = 10 * time.Millisecond
+
+// staleTempAge is the age after which temporary files are removed on start with WithFileLocking. Younger files may
+// belong to writes of other processes.
+const staleTempAge = time.Hour
+
+// journalLockKey is the lock that serializes the commits of transactions and the recovery of the journal
+const journalLockKey = "journal"
+
+// ErrLockTimeout is returned if a file lock could not be acquired within the timeout of WithFileLocking. It is kept
+// here for compatibility, see errors.ErrorLockTimeout.
+var ErrLockTimeout = errors.ErrorLockTimeout
+
+// WithFileLocking takes advisory file locks (flock), so several processes can share a root. Writes and deletes lock
+// the document, creating and deleting directories locks the directory. A lock wait ends with the context of the
+// operation or after timeout with ErrLockTimeout, a timeout of 0 only waits for the context. Transactions are committed
+// one at a time, so a process that starts does not replay the journal of a transaction that is still being applied.
+// Only processes that use WithFileLocking respect the locks. On platforms without flock, only the locks within the
+// process are taken.
+func WithFileLocking(timeout time.Duration) FilesystemOption {
+	return func(f *FilesystemBackend) {
+		f.options |= fileLocking
+		f.lockTimeout = timeout
+	}
+}
+
+// lockFile returns the lock file for key. The key is hashed, so lock files stay flat and short. The lock directory is
+// created with the mode and group of the document directories, so all processes that may write can lock.
+func (f FilesystemBackend) lockFile(key string) (string, error) {
+	dir := filepath.Join(f.Root, lockDir)
+	if err := f.mkdirAll(dir); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(dir, hex.EncodeToString(sum[:16])+".lock"), nil
+}
+
+// openLockFile opens the lock file name. A missing lock file is created with the mode and group of the documents. It
+// is opened read-only, which is enough to lock it and works for every process that may read the documents.
+func (f FilesystemBackend) openLockFile(name string) (*goos.File, error) {
+	for {
+		file, err := goos.Open(name)
+		if !goos.IsNotExist(err) {
+			return file, err
+		}
+		file, err = goos.OpenFile(name, goos.O_CREATE|goos.O_EXCL|goos.O_RDONLY, f.filePerm())
+		if goos.IsExist(err) {
+			// created concurrently
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err = file.Chmod(f.filePerm()); err == nil && f.hasGroup {
+			err = file.Chown(-1, f.gid)
+		}
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		return file, nil
+	}
+}
+
+// fileLock takes the file lock for key, if file locking is enabled
+func (f FilesystemBackend) fileLock(ctx context.Context, key string, exclusive bool) (func(), error) {
+	if f.options&fileLocking == 0 {
+		return func() {}, nil
+	}
+	name, err := f.lockFile(key)
+	if err != nil {
+		return nil, err
+	}
+	if f.lockTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.lockTimeout)
+		defer cancel()
+	}
+	unlock, err := flock(ctx, name, exclusive, f.openLockFile)
+	if err == context.DeadlineExceeded && f.lockTimeout > 0 {
+		return nil, ErrLockTimeout
+	}
+	return unlock, err
+}
+
+// relativeKey returns the path of fullPath below the root, which is the same for all processes sharing the root
+func (f FilesystemBackend) relativeKey(fullPath string) string {
+	rel, err := filepath.Rel(filepath.Clean(f.Root), fullPath)
+	if err != nil {
+		return fullPath
+	}
+	return filepath.ToSlash(rel)
+}
+
+// lockDocument locks the document at fullPath against writers in this and, with file locking, other processes. The
+// directory of the document is locked shared, so it cannot be deleted while the document is written.
+func (f FilesystemBackend) lockDocument(ctx context.Context, fullPath string) (func(), error) {
+	unlockDir, err := f.fileLock(ctx, "dir:"+f.relativeKey(filepath.Dir(fullPath)), false)
+	if err != nil {
+		return nil, err
+	}
+	unlock := documentLocks.Lock(fullPath)
+	unlockFile, err := f.fileLock(ctx, "doc:"+f.relativeKey(fullPath), true)
+	if err != nil {
+		unlock()
+		unlockDir()
+		return nil, err
+	}
+	return func() {
+		unlockFile()
+		unlock()
+		unlockDir()
+	}, nil
+}
+
+// lockDirectory locks the directory at fullPath exclusively, so it can be deleted
+func (f FilesystemBackend) lockDirectory(ctx context.Context, fullPath string) (func(), error) {
+	unlock := documentLocks.Lock(fullPath)
+	unlockFile, err := f.fileLock(ctx, "dir:"+f.relativeKey(fullPath), true)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	return func() {
+		unlockFile()
+		unlock()
+	}, nil
+}
@@ -0,0 +1,110 @@
+//go:build unix
+
+package fs
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skroczek/go-simple-json-store/errors"
+)
+
+func TestFilesystemBackend_FileLockTimeout(t *testing.T) {
+	ctx := context.TODO()
+	be := NewFilesystemBackend(t.TempDir(), WithFileLocking(50*time.Millisecond))
+	name, err := be.lockFile("doc:foo.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := flock(ctx, name, true, be.openLockFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := be.Write(ctx, "/foo.json", []byte("{}")); err != ErrLockTimeout {
+		t.Errorf("Write() of locked document error = %v, want %v", err, ErrLockTimeout)
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := be.Write(canceled, "/foo.json", []byte("{}")); err != context.Canceled {
+		t.Errorf("Write() with canceled context error = %v, want %v", err, context.Canceled)
+	}
+	unlock()
+	if err := be.Write(ctx, "/foo.json", []byte("{}")); err != nil {
+		t.Errorf("Write() after unlock error = %v", err)
+	}
+	if list, _ := be.ListTypes(ctx, "/", os.ModeDir); len(list) != 0 {
+		t.Errorf("ListTypes() shows the lock directory: %v", list)
+	}
+}
+
+func TestFilesystemBackend_FileLockRemovesLockFiles(t *testing.T) {
+	ctx := context.TODO()
+	root := t.TempDir()
+	be := NewFilesystemBackend(root, WithCreateDirs(), WithDeleteEmptyDirs(), WithFileLocking(time.Second))
+	for _, path := range []string{"/foo.json", "/a/bar.json", "/a/b/baz.json"} {
+		if err := be.Write(ctx, path, []byte("{}")); err != nil {
+			t.Fatal(err)
+		}
+		if err := be.Delete(ctx, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// only the lock of the root directory, which is never deleted, is left
+	if entries, _ := os.ReadDir(filepath.Join(root, lockDir)); len(entries) > 1 {
+		t.Errorf("%d lock files left after all documents were deleted", len(entries))
+	}
+}
+
+// TestFilesystemBackend_FileLockHelper increments a counter when run by TestFilesystemBackend_FileLockProcesses
+func TestFilesystemBackend_FileLockHelper(t *testing.T) {
+	root := os.Getenv("GSJS_LOCK_ROOT")
+	if root == "" {
+		t.Skip("only run as helper process")
+	}
+	ctx := context.TODO()
+	be := NewFilesystemBackend(root, WithFileLocking(10*time.Second))
+	for i := 0; i < 50; {
+		data, revision, err := be.GetWithRevision(ctx, "/counter.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, _ := strconv.Atoi(string(data))
+		err = be.WriteIfRevision(ctx, "/counter.json", []byte(strconv.Itoa(n+1)), revision)
+		if err == errors.ErrorRevisionMismatch {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		i++
+	}
+}
+
+func TestFilesystemBackend_FileLockProcesses(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(root+"/counter.json", []byte("0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmd := exec.Command(os.Args[0], "-test.run=^TestFilesystemBackend_FileLockHelper$")
+			cmd.Env = append(os.Environ(), "GSJS_LOCK_ROOT="+root)
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Errorf("helper process failed: %v\n%s", err, out)
+			}
+		}()
+	}
+	wg.Wait()
+	if data, _ := os.ReadFile(root + "/counter.json"); string(data) != "150" {
+		t.Errorf("counter after concurrent increments in 3 processes = %s, want 150", data)
+	}
+}
@@ -0,0 +1,69 @@
+//go:build unix
+
+package fs
+
+import (
+	"context"
+	goos "os"
+	"syscall"
+	"time"
+)
+
+// flock takes an advisory lock on the file name, which is opened with open. It polls, so the wait can be ended by ctx.
+// The holder of an exclusive lock removes the file before it unlocks it, so lock files do not pile up. A process that
+// waited for the lock on the removed file then locks the file that exists now instead.
+func flock(ctx context.Context, name string, exclusive bool, open func(name string) (*goos.File, error)) (func(), error) {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	for {
+		file, err := open(name)
+		if err != nil {
+			return nil, err
+		}
+		if err = flockWait(ctx, file, how); err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		current, err := goos.Stat(name)
+		if err != nil && !goos.IsNotExist(err) {
+			_ = file.Close()
+			return nil, err
+		}
+		if err == nil && goos.SameFile(info, current) {
+			return func() {
+				if exclusive {
+					_ = goos.Remove(name)
+				}
+				_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+				_ = file.Close()
+			}, nil
+		}
+		// the lock file was removed while waiting
+		_ = file.Close()
+	}
+}
+
+// flockWait waits until the lock on file is taken or ctx is done
+func flockWait(ctx context.Context, file *goos.File, how int) error {
+	for {
+		err := syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+		if err == nil {
+			return nil
+		}
+		if err != syscall.EWOULDBLOCK && err != syscall.EINTR {
+			return &goos.PathError{Op: "flock", Path: file.Name(), Err: err}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
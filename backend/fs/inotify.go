@@ -0,0 +1,39 @@
+package fs
+
+import "sync"
+
+// expectations counts the changes the backend is about to make itself, so the file system watcher can tell them apart
+// from changes made by other processes
+type expectations struct {
+	mu    sync.Mutex
+	paths map[string]int
+}
+
+// expect registers a change of fullPath. The returned function cancels the expectation if the change failed.
+func (e *expectations) expect(fullPath string) (cancel func()) {
+	if e == nil {
+		return func() {}
+	}
+	e.mu.Lock()
+	if e.paths == nil {
+		e.paths = make(map[string]int)
+	}
+	e.paths[fullPath]++
+	e.mu.Unlock()
+	return func() {
+		e.consume(fullPath)
+	}
+}
+
+// consume reports whether a change of fullPath was expected and removes the expectation
+func (e *expectations) consume(fullPath string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.paths[fullPath] == 0 {
+		return false
+	}
+	if e.paths[fullPath]--; e.paths[fullPath] == 0 {
+		delete(e.paths, fullPath)
+	}
+	return true
+}
@@ -0,0 +1,200 @@
+//go:build linux
+
+package fs
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"io/fs"
+	"log"
+	goos "os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/skroczek/go-simple-json-store/backend"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM |
+	syscall.IN_DELETE | syscall.IN_DONT_FOLLOW
+
+// inotifyWatcher watches the tree of a FilesystemBackend for changes made by other processes
+type inotifyWatcher struct {
+	backend FilesystemBackend
+	root    string
+	file    *goos.File
+	fd      int
+	// dirs maps the watch descriptors to the directories, it is only used by the read loop after the start
+	dirs map[int32]string
+	// created holds the files that were created but not yet written completely
+	created map[string]bool
+	done    chan struct{}
+	once    sync.Once
+}
+
+// startInotify starts watching the tree of f. Changes that f.expected announces are not reported again.
+func startInotify(f FilesystemBackend) (*inotifyWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	w := &inotifyWatcher{
+		backend: f,
+		root:    filepath.Clean(f.Root),
+		// a non-blocking descriptor is handled by the runtime poller, so Close interrupts a pending read
+		file:    goos.NewFile(uintptr(fd), "inotify"),
+		fd:      fd,
+		dirs:    make(map[int32]string),
+		created: make(map[string]bool),
+		done:    make(chan struct{}),
+	}
+	if err = w.addTree(w.root, false); err != nil {
+		_ = w.file.Close()
+		return nil, err
+	}
+	go w.loop()
+	return w, nil
+}
+
+// addTree watches dir and its subdirectories. With report, the documents found are reported as created, because they
+// were created before the watch was in place.
+func (w *inotifyWatcher) addTree(dir string, report bool) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if goos.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if isInternal(d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			if report && filepath.Ext(d.Name()) == ".json" {
+				w.changed(backend.EventCreate, path)
+			}
+			return nil
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+		if err != nil {
+			return err
+		}
+		w.dirs[int32(wd)] = path
+		return nil
+	})
+}
+
+// removeTree stops watching dir and its subdirectories
+func (w *inotifyWatcher) removeTree(dir string) {
+	for wd, path := range w.dirs {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			_, _ = syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.dirs, wd)
+		}
+	}
+}
+
+func (w *inotifyWatcher) loop() {
+	defer close(w.done)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if !stderrors.Is(err, goos.ErrClosed) {
+				log.Printf("inotify: unable to read events: %v", err)
+			}
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[nameStart:nameStart+int(event.Len)]), "\x00")
+			offset = nameStart + int(event.Len)
+			w.handle(event.Wd, event.Mask, name)
+		}
+	}
+}
+
+func (w *inotifyWatcher) handle(wd int32, mask uint32, name string) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		log.Printf("inotify: event queue overflowed, changes in %s were missed", w.root)
+		// the watchers are disconnected, so that they know that they missed changes, e.g. a cache is cleared
+		w.backend.events.Disconnect()
+		return
+	}
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(w.dirs, wd)
+		return
+	}
+	dir, ok := w.dirs[wd]
+	if !ok || name == "" || isInternal(name) {
+		return
+	}
+	path := filepath.Join(dir, name)
+	if mask&syscall.IN_ISDIR != 0 {
+		switch {
+		case mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+			if err := w.addTree(path, true); err != nil {
+				log.Printf("inotify: unable to watch %s: %v", path, err)
+			}
+		case mask&syscall.IN_MOVED_FROM != 0:
+			w.removeTree(path)
+		}
+		return
+	}
+	if filepath.Ext(name) != ".json" {
+		return
+	}
+	switch {
+	case mask&syscall.IN_CREATE != 0:
+		w.created[path] = true
+	case mask&syscall.IN_CLOSE_WRITE != 0:
+		eventType := backend.EventUpdate
+		if w.created[path] {
+			delete(w.created, path)
+			eventType = backend.EventCreate
+		}
+		w.changed(eventType, path)
+	case mask&syscall.IN_MOVED_TO != 0:
+		if !w.backend.expected.consume(path) {
+			w.changed(backend.EventUpdate, path)
+		}
+	case mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+		delete(w.created, path)
+		if !w.backend.expected.consume(path) {
+			w.backend.publish(backend.EventDelete, path, time.Now())
+		}
+	}
+}
+
+// changed reports a document that was written by another process and logs it if it is not valid JSON
+func (w *inotifyWatcher) changed(eventType backend.EventType, path string) {
+	info, err := goos.Stat(path)
+	if err != nil {
+		// already gone again, the delete is reported on its own
+		return
+	}
+	if data, err := goos.ReadFile(path); err == nil && !json.Valid(data) {
+		log.Printf("inotify: document %s is not valid JSON", path)
+	}
+	w.backend.publish(eventType, path, info.ModTime())
+}
+
+// close stops watching and waits for the read loop to finish
+func (w *inotifyWatcher) close() error {
+	if w == nil {
+		return nil
+	}
+	var err error
+	w.once.Do(func() {
+		err = w.file.Close()
+		<-w.done
+	})
+	return err
+}
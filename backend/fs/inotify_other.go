@@ -0,0 +1,15 @@
+//go:build !linux
+
+package fs
+
+import "fmt"
+
+type inotifyWatcher struct{}
+
+func startInotify(f FilesystemBackend) (*inotifyWatcher, error) {
+	return nil, fmt.Errorf("inotify is only supported on Linux")
+}
+
+func (w *inotifyWatcher) close() error {
+	return nil
+}
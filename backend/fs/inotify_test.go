@@ -0,0 +1,100 @@
+//go:build linux
+
+package fs
+
+import (
+	"context"
+	goos "os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/skroczek/go-simple-json-store/backend"
+)
+
+func TestFilesystemBackend_Inotify(t *testing.T) {
+	root := t.TempDir()
+	be := NewFilesystemBackend(root, WithCreateDirs(), WithInotify())
+	defer be.Close()
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	events, err := be.Watch(ctx, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := func(eventType backend.EventType, path string) {
+		t.Helper()
+		if e := nextEvent(t, events); e.Type != eventType || e.Path != path {
+			t.Errorf("event = %+v, want %s of %s", e, eventType, path)
+		}
+	}
+
+	_ = goos.WriteFile(filepath.Join(root, "foo.json"), []byte("{}"), 0644)
+	expect(backend.EventCreate, "/foo.json")
+	_ = goos.WriteFile(filepath.Join(root, "foo.json"), []byte("[]"), 0644)
+	expect(backend.EventUpdate, "/foo.json")
+
+	_ = goos.MkdirAll(filepath.Join(root, "a", "b"), 0755)
+	// give the watcher time to watch the new directories before the file is created
+	time.Sleep(50 * time.Millisecond)
+	_ = goos.WriteFile(filepath.Join(root, "a", "b", "bar.json"), []byte("{}"), 0644)
+	expect(backend.EventCreate, "/a/b/bar.json")
+
+	_ = goos.Remove(filepath.Join(root, "foo.json"))
+	expect(backend.EventDelete, "/foo.json")
+
+	// changes through the backend are reported once
+	_ = be.Write(ctx, "/baz.json", []byte("{}"))
+	_ = be.Delete(ctx, "/baz.json")
+	_ = goos.WriteFile(filepath.Join(root, "qux.json"), []byte("{}"), 0644)
+	expect(backend.EventCreate, "/baz.json")
+	expect(backend.EventDelete, "/baz.json")
+	expect(backend.EventCreate, "/qux.json")
+}
+
+func TestCache_Inotify(t *testing.T) {
+	root := t.TempDir()
+	be := NewFilesystemBackend(root, WithInotify())
+	defer be.Close()
+	cache := backend.NewCache(1 << 20)
+	cache.SetBackend(be)
+	defer cache.Close()
+	ctx := context.TODO()
+	_ = be.Write(ctx, "/foo.json", []byte(`"old"`))
+	if data, _ := cache.Get(ctx, "/foo.json"); string(data) != `"old"` {
+		t.Fatalf("Get() = %s", data)
+	}
+	_ = goos.WriteFile(filepath.Join(root, "foo.json"), []byte(`"new"`), 0644)
+	deadline := time.Now().Add(time.Second)
+	for {
+		data, _ := cache.Get(ctx, "/foo.json")
+		if string(data) == `"new"` {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Get() after external change = %s, want \"new\"", data)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFilesystemBackend_InotifyOverflow(t *testing.T) {
+	be, err := OpenFilesystemBackend(t.TempDir(), WithInotify())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer be.Close()
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	events, _ := be.Watch(ctx, "/")
+	be.inotify.handle(-1, syscall.IN_Q_OVERFLOW, "")
+	select {
+	case e, ok := <-events:
+		if ok {
+			t.Errorf("event %+v received, want the channel to be closed", e)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("watcher not disconnected after the event queue overflowed")
+	}
+}
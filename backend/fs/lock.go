@@ -0,0 +1,7 @@
+package fs
+
+import "github.com/skroczek/go-simple-json-store/backend"
+
+// documentLocks serializes writes to the same file within the process. It is shared by all FilesystemBackend values,
+// so copies of a backend and backends on the same root lock each other.
+var documentLocks backend.KeyedMutex
@@ -0,0 +1,33 @@
+package fs
+
+import (
+	"github.com/skroczek/go-simple-json-store/errors"
+	"strings"
+)
+
+// directoryPath trims leading and trailing slashes from path and checks that it does not leave the root and does not
+// point to an internal file of the backend
+func directoryPath(path string) (string, error) {
+	path = strings.Trim(path, "/")
+	for _, part := range strings.Split(path, "/") {
+		if part == ".." || isInternal(part) {
+			return "", errors.ErrorInvalidPath
+		}
+	}
+	return path, nil
+}
+
+// documentPath trims leading and trailing slashes from path and checks that it points to a JSON document
+func documentPath(path string) (string, error) {
+	path, err := directoryPath(path)
+	if err != nil {
+		return "", err
+	}
+	if len(path) < 6 {
+		return "", errors.ErrorInvalidPath
+	}
+	if !strings.HasSuffix(path, ".json") {
+		return "", errors.ErrorMissingExtension
+	}
+	return path, nil
+}
@@ -0,0 +1,182 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	goos "os"
+	"path/filepath"
+)
+
+const (
+	defaultFileMode fs.FileMode = 0644
+	defaultDirMode  fs.FileMode = 0755
+)
+
+// WithFileMode sets the permissions of the documents, the default is 0644. The mode is set explicitly, so it does not
+// depend on the umask of the process.
+func WithFileMode(mode fs.FileMode) FilesystemOption {
+	return func(f *FilesystemBackend) {
+		f.fileMode = mode.Perm()
+	}
+}
+
+// WithDirMode sets the permissions of the directories the backend creates, the default is 0755. The mode is set
+// explicitly, so it does not depend on the umask of the process.
+func WithDirMode(mode fs.FileMode) FilesystemOption {
+	return func(f *FilesystemBackend) {
+		f.dirMode = mode.Perm()
+	}
+}
+
+// WithGroup sets the group owner of the documents and directories the backend creates. The process must be a member
+// of the group.
+func WithGroup(gid int) FilesystemOption {
+	return func(f *FilesystemBackend) {
+		f.gid = gid
+		f.hasGroup = true
+	}
+}
+
+// WithPermissionCheck logs the files and directories that do not match the configured modes and group when the backend
+// is created, see CheckPermissions
+func WithPermissionCheck() FilesystemOption {
+	return func(f *FilesystemBackend) {
+		f.options |= checkPermissions
+	}
+}
+
+func (f FilesystemBackend) filePerm() fs.FileMode {
+	if f.fileMode == 0 {
+		return defaultFileMode
+	}
+	return f.fileMode
+}
+
+func (f FilesystemBackend) dirPerm() fs.FileMode {
+	if f.dirMode == 0 {
+		return defaultDirMode
+	}
+	return f.dirMode
+}
+
+// mkdirAll creates dir and its missing parents with the configured mode and group
+func (f FilesystemBackend) mkdirAll(dir string) error {
+	info, err := goos.Stat(dir)
+	if err == nil {
+		if !info.IsDir() {
+			return &goos.PathError{Op: "mkdir", Path: dir, Err: fmt.Errorf("not a directory")}
+		}
+		return nil
+	}
+	if !goos.IsNotExist(err) {
+		return err
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		if err = f.mkdirAll(parent); err != nil {
+			return err
+		}
+	}
+	if err = goos.Mkdir(dir, f.dirPerm()); err != nil {
+		if goos.IsExist(err) {
+			// created concurrently
+			return nil
+		}
+		return err
+	}
+	if err = goos.Chmod(dir, f.dirPerm()); err != nil {
+		return err
+	}
+	if f.hasGroup {
+		return goos.Chown(dir, -1, f.gid)
+	}
+	return nil
+}
+
+// prepareFile sets the group of a file before it replaces the document
+func (f FilesystemBackend) prepareFile(fullPath string, data []byte) func(tmp *goos.File) error {
+	preserve := preserveAttributes(fullPath, data)
+	return func(tmp *goos.File) error {
+		if f.hasGroup {
+			if err := tmp.Chown(-1, f.gid); err != nil {
+				return err
+			}
+		}
+		return preserve(tmp)
+	}
+}
+
+// PermissionMismatch is a file or directory whose permissions or group differ from the configuration
+type PermissionMismatch struct {
+	Path      string      `json:"path"`
+	Mode      fs.FileMode `json:"mode"`
+	WantMode  fs.FileMode `json:"wantMode"`
+	Group     int         `json:"group,omitempty"`
+	WantGroup int         `json:"wantGroup,omitempty"`
+}
+
+func (p PermissionMismatch) String() string {
+	if p.Mode != p.WantMode {
+		return fmt.Sprintf("%s has mode %v, want %v", p.Path, p.Mode, p.WantMode)
+	}
+	return fmt.Sprintf("%s has group %d, want %d", p.Path, p.Group, p.WantGroup)
+}
+
+// CheckPermissions returns the documents and directories below the root whose mode or, with WithGroup, group differ
+// from the configuration. Directories are expected to have the directory mode. Internal files are not checked.
+func (f FilesystemBackend) CheckPermissions(ctx context.Context) ([]PermissionMismatch, error) {
+	root := filepath.Clean(f.Root)
+	var mismatches []PermissionMismatch
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		if isInternal(d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		want := f.filePerm()
+		if d.IsDir() {
+			want = f.dirPerm()
+		} else if !d.Type().IsRegular() || filepath.Ext(d.Name()) != ".json" {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		mismatch := PermissionMismatch{Path: "/" + filepath.ToSlash(rel), Mode: info.Mode().Perm(), WantMode: want}
+		if f.hasGroup {
+			if gid, ok := fileGroup(info); ok {
+				mismatch.Group, mismatch.WantGroup = gid, f.gid
+			}
+		}
+		if mismatch.Mode != mismatch.WantMode || mismatch.Group != mismatch.WantGroup {
+			mismatches = append(mismatches, mismatch)
+		}
+		return nil
+	})
+	return mismatches, err
+}
+
+// logPermissions logs the result of CheckPermissions
+func (f FilesystemBackend) logPermissions() {
+	mismatches, err := f.CheckPermissions(context.Background())
+	if err != nil {
+		log.Printf("unable to check permissions in %s: %v", f.Root, err)
+		return
+	}
+	for _, mismatch := range mismatches {
+		log.Printf("permission mismatch: %s", mismatch)
+	}
+}
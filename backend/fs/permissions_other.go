@@ -0,0 +1,10 @@
+//go:build !unix
+
+package fs
+
+import "io/fs"
+
+// fileGroup is not supported on this platform
+func fileGroup(info fs.FileInfo) (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,84 @@
+//go:build unix
+
+package fs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestFilesystemBackend_Permissions(t *testing.T) {
+	ctx := context.TODO()
+	root := t.TempDir()
+	old := syscall.Umask(0077)
+	defer syscall.Umask(old)
+	be := NewFilesystemBackend(root, WithCreateDirs(), WithFileMode(0640), WithDirMode(0750), WithGroup(os.Getgid()))
+	if err := be.Write(ctx, "/foo/bar/baz.json", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]os.FileMode{"foo": 0750, "foo/bar": 0750, "foo/bar/baz.json": 0640} {
+		info, err := os.Stat(filepath.Join(root, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != want {
+			t.Errorf("mode of %s = %v, want %v", path, info.Mode().Perm(), want)
+		}
+		if gid, _ := fileGroup(info); gid != os.Getgid() {
+			t.Errorf("group of %s = %d, want %d", path, gid, os.Getgid())
+		}
+	}
+
+	mismatches, err := be.CheckPermissions(ctx)
+	if err != nil || len(mismatches) != 0 {
+		t.Errorf("CheckPermissions() = %v, %v, want no mismatches", mismatches, err)
+	}
+	_ = os.Chmod(filepath.Join(root, "foo/bar/baz.json"), 0644)
+	mismatches, err = be.CheckPermissions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 1 || mismatches[0].Path != "/foo/bar/baz.json" || mismatches[0].Mode != 0644 {
+		t.Errorf("CheckPermissions() = %v, want /foo/bar/baz.json with mode 0644", mismatches)
+	}
+	// a rewrite restores the configured mode
+	_ = be.Write(ctx, "/foo/bar/baz.json", []byte("[]"))
+	if mismatches, _ = be.CheckPermissions(ctx); len(mismatches) != 0 {
+		t.Errorf("CheckPermissions() after rewrite = %v, want no mismatches", mismatches)
+	}
+}
+
+func TestFilesystemBackend_LockFilePermissions(t *testing.T) {
+	root := t.TempDir()
+	old := syscall.Umask(0077)
+	defer syscall.Umask(old)
+	be := NewFilesystemBackend(root, WithCreateDirs(), WithFileLocking(0), WithFileMode(0660), WithDirMode(0770),
+		WithGroup(os.Getgid()))
+	if err := be.Write(context.TODO(), "/foo/bar.json", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(root, lockDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) == 0 {
+		t.Fatalf("no lock files in %s: %v", dir, err)
+	}
+	want := map[string]os.FileMode{dir: 0770}
+	for _, entry := range entries {
+		want[filepath.Join(dir, entry.Name())] = 0660
+	}
+	for path, mode := range want {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != mode {
+			t.Errorf("mode of %s = %v, want %v", path, info.Mode().Perm(), mode)
+		}
+		if gid, _ := fileGroup(info); gid != os.Getgid() {
+			t.Errorf("group of %s = %d, want %d", path, gid, os.Getgid())
+		}
+	}
+}
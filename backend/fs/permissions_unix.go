@@ -0,0 +1,16 @@
+//go:build unix
+
+package fs
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileGroup returns the group owner of a file
+func fileGroup(info fs.FileInfo) (int, bool) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(stat.Gid), true
+	}
+	return 0, false
+}
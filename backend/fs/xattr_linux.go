@@ -0,0 +1,68 @@
+//go:build linux
+
+package fs
+
+import (
+	"bytes"
+	"strings"
+	"syscall"
+)
+
+// readAttributes returns the extended attributes of name that start with attributePrefix, without the prefix
+func readAttributes(name string) (map[string]string, error) {
+	size, err := syscall.Listxattr(name, nil)
+	if err != nil || size == 0 {
+		return nil, ignoreUnsupported(unsupported(err))
+	}
+	list := make([]byte, size)
+	if size, err = syscall.Listxattr(name, list); err != nil {
+		return nil, ignoreUnsupported(unsupported(err))
+	}
+	attributes := make(map[string]string)
+	for _, attr := range bytes.Split(list[:size], []byte{0}) {
+		key := string(attr)
+		if !strings.HasPrefix(key, attributePrefix) {
+			continue
+		}
+		size, err := syscall.Getxattr(name, key, nil)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, size)
+		if size, err = syscall.Getxattr(name, key, value); err != nil {
+			return nil, err
+		}
+		attributes[strings.TrimPrefix(key, attributePrefix)] = string(value[:size])
+	}
+	return attributes, nil
+}
+
+// writeAttributes replaces the extended attributes of name that start with attributePrefix. It returns errUnsupported
+// if the file system has no extended attributes.
+func writeAttributes(name string, attributes map[string]string) error {
+	current, err := readAttributes(name)
+	if err != nil {
+		return err
+	}
+	for key := range current {
+		if _, ok := attributes[key]; !ok {
+			if err = syscall.Removexattr(name, attributePrefix+key); err != nil {
+				return unsupported(err)
+			}
+		}
+	}
+	for key, value := range attributes {
+		if err = syscall.Setxattr(name, attributePrefix+key, []byte(value), 0); err != nil {
+			return unsupported(err)
+		}
+	}
+	return nil
+}
+
+// unsupported replaces the error of a file system without extended attributes by errUnsupported
+func unsupported(err error) error {
+	if err == syscall.ENOTSUP {
+		return errUnsupported
+	}
+	return err
+}
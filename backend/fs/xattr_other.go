@@ -0,0 +1,15 @@
+//go:build !linux
+
+package fs
+
+func readAttributes(name string) (map[string]string, error) {
+	return nil, nil
+}
+
+// writeAttributes returns errUnsupported for any attributes, they are only implemented on Linux
+func writeAttributes(name string, attributes map[string]string) error {
+	if len(attributes) == 0 {
+		return nil
+	}
+	return errUnsupported
+}
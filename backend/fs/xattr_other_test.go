@@ -0,0 +1,30 @@
+//go:build !linux
+
+package fs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/skroczek/go-simple-json-store/backend"
+)
+
+func TestFilesystemBackend_WriteWithoutAttributes(t *testing.T) {
+	f := NewFilesystemBackend(t.TempDir())
+	ctx := context.TODO()
+	for _, content := range []string{`{"a":1}`, `{"a":2}`} {
+		if err := f.Write(ctx, "/foo.json", []byte(content)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	info, err := f.Stat(ctx, "/foo.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Hash != backend.ContentRevision([]byte(`{"a":2}`)) || !info.CreateTime.Equal(info.ModTime) {
+		t.Errorf("Stat() = %+v", info)
+	}
+	if err = f.SetMetadata(ctx, "/foo.json", map[string]string{"owner": "alice"}); err != errUnsupported {
+		t.Errorf("SetMetadata() error = %v, want %v", err, errUnsupported)
+	}
+}
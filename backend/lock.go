@@ -0,0 +1,40 @@
+package backend
+
+import "sync"
+
+// KeyedMutex provides a mutex per key. Locks of unused keys are released. The zero value is ready to use.
+type KeyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	refs int
+}
+
+// Lock locks the mutex of key and returns the function to unlock it
+func (k *KeyedMutex) Lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*keyedLock)
+	}
+	lock, ok := k.locks[key]
+	if !ok {
+		lock = &keyedLock{}
+		k.locks[key] = lock
+	}
+	lock.refs++
+	k.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		k.mu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
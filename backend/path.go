@@ -0,0 +1,33 @@
+package backend
+
+import (
+	"strings"
+
+	"github.com/skroczek/go-simple-json-store/errors"
+)
+
+// cleanPath returns path with a single leading and no trailing slash
+func cleanPath(path string) string {
+	return "/" + strings.Trim(path, "/")
+}
+
+// isBelow reports whether the clean path is dir itself or a path below dir
+func isBelow(path, dir string) bool {
+	dir = cleanPath(dir)
+	return dir == "/" || path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+// checkDocumentPath rejects paths that checkPath rejects and paths that do not name a document
+func checkDocumentPath(path string) error {
+	if err := checkPath(path); err != nil {
+		return err
+	}
+	path = strings.Trim(path, "/")
+	if len(path) < 6 {
+		return errors.ErrorInvalidPath
+	}
+	if !strings.HasSuffix(path, ".json") {
+		return errors.ErrorMissingExtension
+	}
+	return nil
+}
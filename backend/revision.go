@@ -0,0 +1,13 @@
+package backend
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// ContentRevision returns a revision token for data. It is used by backends that derive the revision of a document
+// from its content.
+func ContentRevision(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
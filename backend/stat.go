@@ -0,0 +1,20 @@
+package backend
+
+import "context"
+
+// Stat returns the metadata of the document at path. If be does not implement Stater, the document is read to
+// determine its size and hash, the creation time is unknown then.
+func Stat(ctx context.Context, be Backend, path string) (DocumentInfo, error) {
+	if stater, ok := be.(Stater); ok {
+		return stater.Stat(ctx, path)
+	}
+	data, err := be.Get(ctx, path)
+	if err != nil {
+		return DocumentInfo{}, err
+	}
+	modTime, err := be.GetLastModified(ctx, path)
+	if err != nil {
+		return DocumentInfo{}, err
+	}
+	return DocumentInfo{Size: int64(len(data)), ModTime: modTime, Hash: ContentRevision(data)}, nil
+}
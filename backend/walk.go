@@ -0,0 +1,75 @@
+package backend
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Walk calls fn for every document below prefix in the order described by Walker. If be does not implement Walker,
+// or is a proxy whose wrapped backend cannot walk, the tree is walked with List and ListTypes, subdirectories are only
+// visited if be implements FileBackend. A prefix with a ".." segment is rejected with errors.ErrorInvalidPath.
+func Walk(ctx context.Context, be Backend, prefix string, fn WalkFunc) error {
+	if err := checkPath(prefix); err != nil {
+		return err
+	}
+	if walker, ok := be.(Walker); ok {
+		if err := walker.Walk(ctx, prefix, fn); !stderrors.Is(err, ErrNotSupported) {
+			return err
+		}
+	}
+	return walkList(ctx, be, strings.TrimSuffix(cleanPath(prefix), "/"), fn)
+}
+
+// walkTree is Walk, but fails with ErrNotSupported if be cannot visit subdirectories instead of visiting only the top
+// level. Proxies use it to implement Walker.
+func walkTree(ctx context.Context, be Backend, prefix string, fn WalkFunc) error {
+	if err := checkPath(prefix); err != nil {
+		return err
+	}
+	if walker, ok := be.(Walker); ok {
+		return walker.Walk(ctx, prefix, fn)
+	}
+	if _, ok := be.(FileBackend); !ok {
+		return fmt.Errorf("%w: %T cannot walk subdirectories", ErrNotSupported, be)
+	}
+	return walkList(ctx, be, strings.TrimSuffix(cleanPath(prefix), "/"), fn)
+}
+
+func walkList(ctx context.Context, be Backend, dir string, fn WalkFunc) error {
+	names, err := be.List(ctx, dir)
+	if err != nil {
+		return err
+	}
+	dirs := make(map[string]bool)
+	if fbe, ok := be.(FileBackend); ok {
+		subdirs, err := fbe.ListTypes(ctx, dir, fs.ModeDir)
+		if err != nil {
+			return err
+		}
+		for _, name := range subdirs {
+			dirs[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if dirs[name] {
+			if err := walkList(ctx, be, dir+"/"+name, fn); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		if err := fn(dir + "/" + name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,7 +4,20 @@ import "errors"
 
 var ErrorMissingExtension = errors.New("missing extension")
 var ErrorInvalidPath = errors.New("invalid path")
+var ErrorAlreadyExists = errors.New("document already exists")
+var ErrorQuotaExceeded = errors.New("quota exceeded")
+var ErrorDocumentTooLarge = errors.New("document too large")
+var ErrorRevisionMismatch = errors.New("revision mismatch")
+
+// ErrorLockTimeout is returned if a lock could not be acquired in time, the request may be retried
+var ErrorLockTimeout = errors.New("timeout waiting for file lock")
 
 func IsClientError(err error) bool {
-	return err == ErrorMissingExtension || err == ErrorInvalidPath
+	return err == ErrorMissingExtension || err == ErrorInvalidPath || err == ErrorAlreadyExists || err == ErrorRevisionMismatch ||
+		IsQuotaError(err)
+}
+
+// IsQuotaError reports whether err was caused by a storage quota
+func IsQuotaError(err error) bool {
+	return err == ErrorQuotaExceeded || err == ErrorDocumentTooLarge
 }
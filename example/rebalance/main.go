@@ -0,0 +1,52 @@
+// Command rebalance moves the documents of a sharded file system store after the number of shards changed.
+//
+//	go run example/rebalance/main.go -from /mnt/disk1,/mnt/disk2 -to /mnt/disk1,/mnt/disk2,/mnt/disk3
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/skroczek/go-simple-json-store/backend"
+	"github.com/skroczek/go-simple-json-store/backend/fs"
+)
+
+func shards(roots string, backends map[string]backend.Backend) []backend.Backend {
+	var list []backend.Backend
+	for _, root := range strings.Split(roots, ",") {
+		root, err := filepath.Abs(root)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// the same root must map to the same backend, so documents already on the right shard stay where they are
+		if _, ok := backends[root]; !ok {
+			backends[root] = fs.NewFilesystemBackend(root, fs.WithCreateDirs(), fs.WithDeleteEmptyDirs())
+		}
+		list = append(list, backends[root])
+	}
+	return list
+}
+
+func main() {
+	from := flag.String("from", "", "comma separated shard roots before the change")
+	to := flag.String("to", "", "comma separated shard roots after the change")
+	flag.Parse()
+	if *from == "" || *to == "" {
+		flag.Usage()
+		return
+	}
+	backends := make(map[string]backend.Backend)
+	previous := shards(*from, backends)
+	sharded, err := backend.NewSharded(shards(*to, backends)...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	moved, err := sharded.Rebalance(context.Background(), previous...)
+	log.Printf("moved %d documents", moved)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
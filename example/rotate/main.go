@@ -0,0 +1,75 @@
+// Command rotate encrypts all documents of an encrypted file system store with a new key. Keys are read from files
+// and given as id=file. The previous keys are only used to read documents that were not rotated yet, the passphrase in
+// GO_SIMPLE_JSON_STORE_PASSPHRASE is added to them if it is set. An interrupted rotation continues from the checkpoint.
+// The store is opened with file locking, a server using the store at the same time must use fs.WithFileLocking too.
+//
+//	go run example/rotate/main.go -root /var/lib/store -key 2024=/etc/store/2024.key -previous 2023=/etc/store/2023.key
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/skroczek/go-simple-json-store/backend"
+	"github.com/skroczek/go-simple-json-store/backend/fs"
+)
+
+func fileKey(spec string) backend.KeyProvider {
+	id, name, ok := strings.Cut(spec, "=")
+	if !ok || id == "" || name == "" {
+		log.Fatalf("invalid key %q, expected id=file", spec)
+	}
+	return backend.FileKeyProvider(id, name)
+}
+
+func main() {
+	root := flag.String("root", "", "root directory of the store")
+	key := flag.String("key", "", "the new key as id=file")
+	previous := flag.String("previous", "", "comma separated previous keys as id=file")
+	checkpoint := flag.String("checkpoint", "", "checkpoint file, defaults to .rotate.json next to the root")
+	lockTimeout := flag.Duration("lock-timeout", 30*time.Second, "how long to wait for a document locked by the server")
+	flag.Parse()
+	if *root == "" || *key == "" {
+		flag.Usage()
+		return
+	}
+	dir, err := filepath.Abs(*root)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *checkpoint == "" {
+		*checkpoint = dir + ".rotate.json"
+	}
+	var keys []backend.KeyProvider
+	if *previous != "" {
+		for _, spec := range strings.Split(*previous, ",") {
+			keys = append(keys, fileKey(spec))
+		}
+	}
+	if _, ok := os.LookupEnv(backend.PassphraseEnv); ok {
+		keys = append(keys, backend.DefaultKeyProvider())
+	}
+	be := fs.NewFilesystemBackend(dir, fs.WithCreateDirs(), fs.WithFileLocking(*lockTimeout))
+	encrypted := backend.NewEncrypted(be, backend.NewKeyRing(fileKey(*key), keys...))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	progress, err := encrypted.Rotate(ctx, backend.RotateOptions{
+		Checkpoint: *checkpoint,
+		Progress: func(progress backend.RotateProgress) {
+			if progress.Checked%1000 == 0 {
+				log.Printf("checked %d documents, rotated %d", progress.Checked, progress.Rotated)
+			}
+		},
+	})
+	log.Printf("checked %d documents, rotated %d", progress.Checked, progress.Rotated)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
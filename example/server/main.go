@@ -26,9 +26,9 @@ func main() {
 	be := fs.NewFilesystemBackend(root, fs.WithCreateDirs(), fs.WithDeleteEmptyDirs())
 	s := server.NewServer(
 		server.WithBackend(be),
-		// You can additional add the encrypted backend to encrypt the data as rest. But you have to set the passphrase
-		// in the environment variable GO_SIMPLE_JSON_STORE_PASSPHRASE
-		//pkg.WithBackend(backend.NewEncrypted(be)),
+		// You can additional add the encrypted backend to encrypt the data as rest. The default key provider reads
+		// the passphrase from the environment variable GO_SIMPLE_JSON_STORE_PASSPHRASE
+		//server.WithBackend(backend.NewEncrypted(be, backend.DefaultKeyProvider())),
 		server.WithRouterOptions(
 			router.WithDefaultCors(true),
 			// You can add the basic auth middleware to protect the server with a username and password.
@@ -3,56 +3,31 @@
 package helper
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
 	"crypto/md5"
-	"crypto/rand"
 	"encoding/hex"
-	"io"
-	"os"
 )
 
+// PassphraseKeyID is the key ID of documents encrypted with the passphrase from the environment, see
+// backend.DefaultKeyProvider
+const PassphraseKeyID = "env"
+
+// createHash is the key derivation of the legacy format. It is only used to read existing data.
 func createHash(key string) string {
 	hasher := md5.New()
 	hasher.Write([]byte(key))
 	return hex.EncodeToString(hasher.Sum(nil))
 }
 
-func Encrypt(data []byte) []byte {
-	passphrase, ok := os.LookupEnv("GO_SIMPLE_JSON_STORE_PASSPHRASE")
-	if !ok {
-		panic("No passphrase set. Please set the GO_SIMPLE_JSON_STORE_PASSPHRASE environment variable.")
-	}
-	block, _ := aes.NewCipher([]byte(createHash(passphrase)))
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		panic(err.Error())
-	}
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
-		panic(err.Error())
-	}
-	return gcm.Seal(nonce, nonce, data, nil)
-}
-
-func Decrypt(data []byte, err error) ([]byte, error) {
+// openLegacy decrypts data in the legacy format, the nonce followed by the ciphertext with a key derived by createHash
+func openLegacy(secret []byte, data []byte) ([]byte, error) {
+	gcm, err := newGCM([]byte(createHash(string(secret))))
 	if err != nil {
 		return nil, err
 	}
-	passphrase, ok := os.LookupEnv("GO_SIMPLE_JSON_STORE_PASSPHRASE")
-	if !ok {
-		panic("No passphrase set. Please set the GO_SIMPLE_JSON_STORE_PASSPHRASE environment variable.")
-	}
-	key := []byte(createHash(passphrase))
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		panic(err.Error())
-	}
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		panic(err.Error())
-	}
 	nonceSize := gcm.NonceSize()
+	if len(data) < nonceSize {
+		return nil, ErrInvalidEnvelope
+	}
 	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
 	return gcm.Open(nil, nonce, ciphertext, nil)
 }
@@ -0,0 +1,286 @@
+package helper
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// envelopeMagic starts every document encrypted by Seal. Data without it is in the legacy format.
+var envelopeMagic = []byte("GSJE")
+
+const envelopeVersion = 1
+
+const (
+	// AlgorithmAES256GCM encrypts with AES-256 in GCM mode
+	AlgorithmAES256GCM byte = 1
+	// KDFArgon2id derives the key with Argon2id, 1 pass over 64 MiB with 4 threads
+	KDFArgon2id byte = 1
+)
+
+const saltSize = 16
+
+// LegacyKeyID is the key ID Open asks for to decrypt data in the legacy format, which carries no key ID
+const LegacyKeyID = ""
+
+// ErrInvalidEnvelope is returned by Open for data that starts like an envelope but cannot be parsed
+var ErrInvalidEnvelope = errors.New("invalid encryption envelope")
+
+// Key is a secret with the ID that is stored in the envelope, so the secret can be found again for decryption
+type Key struct {
+	ID     string
+	Secret []byte
+	// Salt is the salt new documents are sealed with. It should be the same for all documents of a store, so the key
+	// is only derived once, see NewSalt. Seal chooses a random salt per process if it is empty.
+	Salt []byte
+}
+
+// envelope is the parsed header of an encrypted document
+type envelope struct {
+	version   byte
+	algorithm byte
+	kdf       byte
+	keyID     string
+	salt      []byte
+	nonce     []byte
+	// header is the raw header, it is authenticated as additional data
+	header     []byte
+	ciphertext []byte
+}
+
+// derivedKey is an entry of the cache of derived keys. done is closed when the key is derived.
+type derivedKey struct {
+	id   [sha256.Size]byte
+	done chan struct{}
+	key  []byte
+}
+
+// derivedKeys caches derived keys, because the KDF is slow on purpose. The least recently used keys are evicted.
+var derivedKeys = struct {
+	sync.Mutex
+	keys map[[sha256.Size]byte]*list.Element
+	lru  *list.List
+	// salts holds the salt used for new documents per secret, for keys without a salt
+	salts map[[sha256.Size]byte][]byte
+}{keys: make(map[[sha256.Size]byte]*list.Element), lru: list.New(), salts: make(map[[sha256.Size]byte][]byte)}
+
+// maxDerivedKeys bounds the cache of derived keys
+const maxDerivedKeys = 1024
+
+// derivationID identifies a derived key. The fields are length-prefixed, so different salts and secrets cannot
+// produce the same input.
+func derivationID(kdf byte, secret, salt []byte) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write([]byte{kdf})
+	for _, field := range [][]byte{salt, secret} {
+		var size [8]byte
+		binary.BigEndian.PutUint64(size[:], uint64(len(field)))
+		h.Write(size[:])
+		h.Write(field)
+	}
+	var id [sha256.Size]byte
+	copy(id[:], h.Sum(nil))
+	return id
+}
+
+func deriveKey(kdf byte, secret, salt []byte) ([]byte, error) {
+	if kdf != KDFArgon2id {
+		return nil, fmt.Errorf("unknown key derivation function %d", kdf)
+	}
+	id := derivationID(kdf, secret, salt)
+	derivedKeys.Lock()
+	if element, ok := derivedKeys.keys[id]; ok {
+		derivedKeys.lru.MoveToFront(element)
+		derivedKeys.Unlock()
+		entry := element.Value.(*derivedKey)
+		<-entry.done
+		return entry.key, nil
+	}
+	entry := &derivedKey{id: id, done: make(chan struct{})}
+	derivedKeys.keys[id] = derivedKeys.lru.PushFront(entry)
+	for derivedKeys.lru.Len() > maxDerivedKeys {
+		oldest := derivedKeys.lru.Back()
+		derivedKeys.lru.Remove(oldest)
+		delete(derivedKeys.keys, oldest.Value.(*derivedKey).id)
+	}
+	derivedKeys.Unlock()
+	// the key is derived without holding the lock, only lookups of the same key wait for it
+	entry.key = argon2.IDKey(secret, salt, 1, 64*1024, 4, 32)
+	close(entry.done)
+	return entry.key, nil
+}
+
+// NewSalt returns a random salt for Key.Salt
+func NewSalt() ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// writeSalt returns the salt for new documents encrypted with a key without salt. It is chosen randomly once per
+// secret and process, so the key is only derived once per process.
+func writeSalt(secret []byte) ([]byte, error) {
+	id := sha256.Sum256(secret)
+	derivedKeys.Lock()
+	defer derivedKeys.Unlock()
+	if salt, ok := derivedKeys.salts[id]; ok {
+		return salt, nil
+	}
+	salt, err := NewSalt()
+	if err != nil {
+		return nil, err
+	}
+	derivedKeys.salts[id] = salt
+	return salt, nil
+}
+
+// Seal encrypts data with key and returns it in an envelope. The envelope header holds the format version, the
+// algorithm, the key derivation function, the key ID, the salt and the nonce, and is authenticated along with the data.
+func Seal(key Key, data []byte) ([]byte, error) {
+	if len(key.ID) > 255 {
+		return nil, fmt.Errorf("key ID %q is too long", key.ID)
+	}
+	if len(key.Secret) == 0 {
+		return nil, fmt.Errorf("key %q has no secret", key.ID)
+	}
+	salt := key.Salt
+	if len(salt) == 0 {
+		var err error
+		if salt, err = writeSalt(key.Secret); err != nil {
+			return nil, err
+		}
+	}
+	if len(salt) > 255 {
+		return nil, fmt.Errorf("salt of key %q is too long", key.ID)
+	}
+	derived, err := deriveKey(KDFArgon2id, key.Secret, salt)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(derived)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	header := make([]byte, 0, len(envelopeMagic)+5+len(key.ID)+len(salt)+len(nonce))
+	header = append(header, envelopeMagic...)
+	header = append(header, envelopeVersion, AlgorithmAES256GCM, KDFArgon2id, byte(len(key.ID)))
+	header = append(header, key.ID...)
+	header = append(header, byte(len(salt)))
+	header = append(header, salt...)
+	header = append(header, nonce...)
+	return gcm.Seal(header, nonce, data, header), nil
+}
+
+// Open decrypts data sealed by Seal. lookup returns the key for the key ID in the envelope. Data in the legacy format
+// is decrypted with the key lookup returns for LegacyKeyID.
+func Open(data []byte, lookup func(id string) (Key, error)) ([]byte, error) {
+	if !IsEnvelope(data) {
+		key, err := lookup(LegacyKeyID)
+		if err != nil {
+			return nil, err
+		}
+		return openLegacy(key.Secret, data)
+	}
+	e, err := parseEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	key, err := lookup(e.keyID)
+	if err != nil {
+		return nil, err
+	}
+	derived, err := deriveKey(e.kdf, key.Secret, e.salt)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(derived)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, e.nonce, e.ciphertext, e.header)
+}
+
+// IsEnvelope reports whether data starts with an envelope header
+func IsEnvelope(data []byte) bool {
+	return bytes.HasPrefix(data, envelopeMagic)
+}
+
+// EnvelopeKeyID returns the ID of the key data was sealed with
+func EnvelopeKeyID(data []byte) (string, error) {
+	if !IsEnvelope(data) {
+		return LegacyKeyID, nil
+	}
+	e, err := parseEnvelope(data)
+	if err != nil {
+		return "", err
+	}
+	return e.keyID, nil
+}
+
+func parseEnvelope(data []byte) (*envelope, error) {
+	e := &envelope{}
+	r := data[len(envelopeMagic):]
+	// next returns the next n bytes of the header
+	next := func(n int) ([]byte, bool) {
+		if len(r) < n {
+			return nil, false
+		}
+		b := r[:n]
+		r = r[n:]
+		return b, true
+	}
+	fixed, ok := next(4)
+	if !ok {
+		return nil, ErrInvalidEnvelope
+	}
+	e.version, e.algorithm, e.kdf = fixed[0], fixed[1], fixed[2]
+	if e.version != envelopeVersion {
+		return nil, fmt.Errorf("unsupported envelope version %d", e.version)
+	}
+	if e.algorithm != AlgorithmAES256GCM {
+		return nil, fmt.Errorf("unsupported encryption algorithm %d", e.algorithm)
+	}
+	keyID, ok := next(int(fixed[3]))
+	if !ok {
+		return nil, ErrInvalidEnvelope
+	}
+	e.keyID = string(keyID)
+	saltLen, ok := next(1)
+	if !ok {
+		return nil, ErrInvalidEnvelope
+	}
+	if e.salt, ok = next(int(saltLen[0])); !ok {
+		return nil, ErrInvalidEnvelope
+	}
+	// AES-GCM uses the standard nonce size
+	if e.nonce, ok = next(12); !ok {
+		return nil, ErrInvalidEnvelope
+	}
+	e.header = data[:len(data)-len(r)]
+	e.ciphertext = r
+	return e, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -1,5 +1,41 @@
 package server
 
-import "fmt"
+import (
+	stderrors "errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/skroczek/go-simple-json-store/errors"
+	"net/http"
+	"os"
+)
 
 var errMethodNotAllowed = fmt.Errorf("method not allowed")
+
+// abortWithBackendError aborts the request with the status code matching an error returned by the backend
+func abortWithBackendError(c *gin.Context, err error) {
+	if stderrors.Is(err, os.ErrNotExist) {
+		_ = c.AbortWithError(http.StatusNotFound, err)
+		return
+	}
+	if err == errors.ErrorDocumentTooLarge {
+		_ = c.AbortWithError(http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	if err == errors.ErrorQuotaExceeded {
+		_ = c.AbortWithError(http.StatusInsufficientStorage, err)
+		return
+	}
+	if err == errors.ErrorAlreadyExists || err == errors.ErrorRevisionMismatch {
+		_ = c.AbortWithError(http.StatusConflict, err)
+		return
+	}
+	if err == errors.ErrorLockTimeout {
+		_ = c.AbortWithError(http.StatusServiceUnavailable, err)
+		return
+	}
+	if errors.IsClientError(err) {
+		_ = c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	_ = c.AbortWithError(http.StatusInternalServerError, err)
+}
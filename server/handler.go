@@ -1,36 +1,44 @@
 package server
 
 import (
+	"encoding/json"
+	stderrors "errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/skroczek/go-simple-json-store/backend/fs"
+	"github.com/skroczek/go-simple-json-store/backend"
 	"github.com/skroczek/go-simple-json-store/errors"
 	"github.com/skroczek/go-simple-json-store/helper"
 	"io"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 )
 
-// GetHandler handles GET requests
+// GetHandler handles GET requests. The document is sent as it is stored, so the body matches the size and hash
+// reported by HEAD.
 func (s *Server) GetHandler(c *gin.Context) {
 	path := c.Request.URL.Path
-	data, err := helper.FromJSON(s.Backend.Get(c, path))
+	data, err := s.Backend.Get(c, path)
 	if err != nil {
-		if os.IsNotExist(err) {
-			_ = c.AbortWithError(http.StatusNotFound, err)
-			return
-		}
-		if errors.IsClientError(err) {
-			_ = c.AbortWithError(http.StatusBadRequest, err)
-			return
-		}
-		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		abortWithBackendError(c, err)
+		return
+	}
+	if !json.Valid(data) {
+		_ = c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("document %s is not valid JSON", path))
 		return
 	}
 	modTime, _ := s.Backend.GetLastModified(c, path)
 	c.Header("Last-Modified", modTime.Format(time.RFC1123))
-	c.JSON(http.StatusOK, data)
+	c.Header("ETag", etag(backend.ContentRevision(data)))
+	c.Data(http.StatusOK, jsonContentType, data)
+}
+
+const jsonContentType = "application/json; charset=utf-8"
+
+// etag formats a content hash as strong entity tag
+func etag(hash string) string {
+	return `"` + hash + `"`
 }
 
 // PostHandler handles POST requests
@@ -48,15 +56,8 @@ func (s *Server) PutHandler(c *gin.Context) {
 	}
 	err = s.Backend.Write(c, urlPath, helper.ToJSON(data))
 	if err != nil {
-		if os.IsNotExist(err) {
-			_ = c.AbortWithError(http.StatusNotFound, err)
-			return
-		}
-		if errors.IsClientError(err) {
-			_ = c.AbortWithError(http.StatusBadRequest, err)
-			return
-		}
-		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		abortWithBackendError(c, err)
+		return
 	}
 	c.Status(http.StatusCreated)
 }
@@ -66,7 +67,8 @@ func (s *Server) DeleteHandler(c *gin.Context) {
 	urlPath := c.Request.URL.Path
 	err := s.Backend.Delete(c, urlPath)
 	if err != nil {
-		if _, ok := err.(*fs.DeleteDirectoryError); ok {
+		var deleteDirectoryError *backend.DeleteDirectoryError
+		if stderrors.As(err, &deleteDirectoryError) {
 			_ = c.AbortWithError(http.StatusMethodNotAllowed, err)
 			return
 		}
@@ -84,50 +86,88 @@ func (s *Server) DeleteHandler(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
-// PatchHandler handles PATCH requests
-func (s *Server) PatchHandler(c *gin.Context) {
-	urlPath := c.Request.URL.Path
-	object, err := helper.FromJSON(s.Backend.Get(c, urlPath))
-	if err != nil {
-		if os.IsNotExist(err) {
-			_ = c.AbortWithError(http.StatusNotFound, err)
-			return
+// patchRetries is how often PatchHandler retries a patch that lost the race against a concurrent write
+const patchRetries = 5
+
+// mergePatch merges patchData into object. Maps are merged recursively, slices are appended.
+func mergePatch(object, patchData interface{}) (interface{}, error) {
+	if patchDataMap, ok := patchData.(map[string]interface{}); ok {
+		if dataMap, ok := object.(map[string]interface{}); ok {
+			return helper.MergeMap(dataMap, patchDataMap), nil
 		}
-		_ = c.AbortWithError(http.StatusInternalServerError, err)
-		return
+		// TODO: maybe replace original object with patchDataMap?
+		return nil, fmt.Errorf("unable to merge map with %T", object)
 	}
+	if patchDataSlice, ok := patchData.([]interface{}); ok {
+		if dataSlice, ok := object.([]interface{}); ok {
+			return append(dataSlice, patchDataSlice...), nil
+		}
+		// TODO: maybe replace original object with patchDataSlice?
+		return nil, fmt.Errorf("unable to merge slice with %T", object)
+	}
+	return nil, fmt.Errorf("unable to merge %T with %T", object, patchData)
+}
+
+// PatchHandler handles PATCH requests. If the backend implements backend.ConditionalBackend, the document is only
+// replaced if it did not change since it was read, otherwise the patch is applied again to the new content.
+func (s *Server) PatchHandler(c *gin.Context) {
+	urlPath := c.Request.URL.Path
 	patchData, _ := helper.FromJSON(io.ReadAll(c.Request.Body))
-	if patchDataMap, ok := patchData.(map[string]interface{}); ok {
-		if dataMap, ok := object.(map[string]interface{}); ok {
-			object = helper.MergeMap(dataMap, patchDataMap)
+	cbe, conditional := s.Backend.(backend.ConditionalBackend)
+	for attempt := 1; ; attempt++ {
+		var data []byte
+		var revision string
+		var err error
+		if conditional {
+			data, revision, err = cbe.GetWithRevision(c, urlPath)
+			if stderrors.Is(err, backend.ErrNotSupported) {
+				// a proxy in front of a backend without compare-and-swap writes
+				conditional = false
+				data, err = s.Backend.Get(c, urlPath)
+			}
 		} else {
-			// TODO: maybe replace original object with patchDataMap?
-			_ = c.AbortWithError(http.StatusBadRequest, fmt.Errorf("unable to merge map with %T", object))
+			data, err = s.Backend.Get(c, urlPath)
+		}
+		object, err := helper.FromJSON(data, err)
+		if err != nil {
+			abortWithBackendError(c, err)
 			return
 		}
-	} else if patchDataSlice, ok := patchData.([]interface{}); ok {
-		if dataSlice, ok := object.([]interface{}); ok {
-			object = append(dataSlice, patchDataSlice...)
+		object, err = mergePatch(object, patchData)
+		if err != nil {
+			_ = c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		if conditional {
+			err = cbe.WriteIfRevision(c, urlPath, helper.ToJSON(object), revision)
+			if err == errors.ErrorRevisionMismatch && attempt < patchRetries {
+				continue
+			}
 		} else {
-			// TODO: maybe replace original object with patchDataSlice?
-			_ = c.AbortWithError(http.StatusBadRequest, fmt.Errorf("unable to merge slice with %T", object))
+			err = s.Backend.Write(c, urlPath, helper.ToJSON(object))
+		}
+		if err != nil {
+			abortWithBackendError(c, err)
 			return
 		}
-	} else {
-		_ = c.AbortWithError(http.StatusBadRequest, fmt.Errorf("unable to merge %T with %T", object, patchData))
-		return
-	}
-	err = s.Backend.Write(c, urlPath, helper.ToJSON(object))
-	if err != nil {
-		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		c.Status(http.StatusCreated)
 		return
 	}
-	c.Status(http.StatusCreated)
 }
 
-// HeadHandler handles HEAD requests
+// HeadHandler handles HEAD requests. The headers are taken from backend.Stat, backends that implement
+// backend.Stater answer without reading the document.
 func (s *Server) HeadHandler(c *gin.Context) {
-	s.GetHandler(c)
+	info, err := backend.Stat(c, s.Backend, c.Request.URL.Path)
+	if err != nil {
+		abortWithBackendError(c, err)
+		return
+	}
+	c.Header("Content-Type", jsonContentType)
+	c.Header("Content-Length", strconv.FormatInt(info.Size, 10))
+	c.Header("Last-Modified", info.ModTime.Format(time.RFC1123))
+	c.Header("ETag", etag(info.Hash))
+	c.Status(http.StatusOK)
 }
 
 // OptionsHandler handles OPTIONS requests
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/skroczek/go-simple-json-store/backend"
+	"github.com/skroczek/go-simple-json-store/helper"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const optionRevisions = "revisions"
+const optionRevision = "revision"
+const optionRestore = "restore"
+
+// revisionQuery parses the revision in the query parameter key. It reports whether the parameter is present.
+func revisionQuery(c *gin.Context, key string) (int, bool, error) {
+	value, ok := c.GetQuery(key)
+	if !ok {
+		return 0, false, nil
+	}
+	revision, err := strconv.Atoi(value)
+	if err != nil || revision < 1 {
+		return 0, true, fmt.Errorf("invalid revision %q", value)
+	}
+	return revision, true, nil
+}
+
+func historyHandler(c *gin.Context, be backend.HistoryBackend) bool {
+	urlPath := c.Request.URL.Path
+	if _, ok := c.GetQuery(optionRevisions); ok && c.Request.Method == http.MethodGet {
+		revisions, err := be.Revisions(c, urlPath)
+		if err != nil {
+			abortWithBackendError(c, err)
+			return true
+		}
+		c.AbortWithStatusJSON(http.StatusOK, revisions)
+		return true
+	}
+	if revision, ok, err := revisionQuery(c, optionRevision); ok && c.Request.Method == http.MethodGet {
+		if err != nil {
+			_ = c.AbortWithError(http.StatusBadRequest, err)
+			return true
+		}
+		data, err := helper.FromJSON(be.GetRevision(c, urlPath, revision))
+		if err != nil {
+			abortWithBackendError(c, err)
+			return true
+		}
+		c.AbortWithStatusJSON(http.StatusOK, data)
+		return true
+	}
+	if revision, ok, err := revisionQuery(c, optionRestore); ok && c.Request.Method == http.MethodPost {
+		if err != nil {
+			_ = c.AbortWithError(http.StatusBadRequest, err)
+			return true
+		}
+		if err := be.RestoreRevision(c, urlPath, revision); err != nil {
+			abortWithBackendError(c, err)
+			return true
+		}
+		modTime, _ := be.GetLastModified(c, urlPath)
+		c.Header("Last-Modified", modTime.Format(time.RFC1123))
+		c.AbortWithStatus(http.StatusCreated)
+		return true
+	}
+	return false
+}
+
+// WithHistory exposes the revisions of a backend.HistoryBackend:
+// GET ?revisions lists the revisions of a document, GET ?revision=n returns revision n and POST ?restore=n restores
+// revision n.
+func WithHistory() Options {
+	return func(s *Server) {
+		if b, ok := s.Backend.(backend.HistoryBackend); ok {
+			s.AddRouterOption(func(r *gin.Engine) {
+				r.Use(func(c *gin.Context) {
+					if historyHandler(c, b) {
+						return
+					}
+					c.Next()
+				})
+			})
+		} else {
+			log.Panicf("Error: backend does not implement backend.HistoryBackend")
+		}
+	}
+}
@@ -5,24 +5,55 @@ import (
 	"github.com/skroczek/go-simple-json-store/backend"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 )
 
 const listAllSuffix = "__list.json"
 const optionWithoutExtension = "withoutExtension"
+const optionDetails = "details"
+
+// listEntry is an entry of a detailed listing
+type listEntry struct {
+	Name string `json:"name"`
+	backend.DocumentInfo
+}
 
 func getListHandler(c *gin.Context, be backend.Backend) {
 	urlPath := c.Request.URL.Path
-	data, err := be.List(c, urlPath[0:len(urlPath)-len(listAllSuffix)])
+	dir := urlPath[0 : len(urlPath)-len(listAllSuffix)]
+	data, err := be.List(c, dir)
 	if err != nil {
 		if os.IsNotExist(err) {
 			_ = c.AbortWithError(http.StatusNotFound, err)
 			return
 		}
 		_ = c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	_, withoutExtension := c.GetQuery(optionWithoutExtension)
+	if _, ok := c.GetQuery(optionDetails); ok {
+		entries := make([]listEntry, 0, len(data))
+		for _, name := range data {
+			info, err := backend.Stat(c, be, path.Join(dir, name))
+			if err != nil {
+				if os.IsNotExist(err) {
+					// deleted since it was listed
+					continue
+				}
+				_ = c.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+			if withoutExtension {
+				name = strings.TrimSuffix(name, filepath.Ext(name))
+			}
+			entries = append(entries, listEntry{Name: name, DocumentInfo: info})
+		}
+		c.AbortWithStatusJSON(http.StatusOK, entries)
+		return
 	}
-	if _, ok := c.GetQuery(optionWithoutExtension); ok {
+	if withoutExtension {
 		for i, v := range data {
 			data[i] = strings.TrimSuffix(v, filepath.Ext(v))
 		}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/skroczek/go-simple-json-store/backend"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const quotaSuffix = "__quota.json"
+
+func getQuotaHandler(c *gin.Context, be backend.QuotaBackend) {
+	urlPath := c.Request.URL.Path
+	usage, err := be.Usage(c, urlPath[0:len(urlPath)-len(quotaSuffix)])
+	if err != nil {
+		abortWithBackendError(c, err)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusOK, usage)
+}
+
+// WithQuota adds the magic URL __quota.json, which returns the usage of the quotas that apply to the directory
+func WithQuota() Options {
+	return func(s *Server) {
+		if b, ok := s.Backend.(backend.QuotaBackend); ok {
+			s.AddRouterOption(func(r *gin.Engine) {
+				r.Use(func(c *gin.Context) {
+					if strings.HasSuffix(c.Request.URL.Path, quotaSuffix) {
+						if c.Request.Method != http.MethodGet {
+							_ = c.AbortWithError(http.StatusMethodNotAllowed, errMethodNotAllowed)
+							return
+						}
+						getQuotaHandler(c, b)
+						return
+					}
+					c.Next()
+				})
+			})
+		} else {
+			log.Panicf("Error: backend does not implement backend.QuotaBackend")
+		}
+	}
+}
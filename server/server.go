@@ -26,6 +26,8 @@ func NewServer(opts ...Options) *Server {
 
 func (s *Server) prepareEngine() *gin.Engine {
 	r := gin.New()
+	// the handlers pass the gin context to the backend, which must see the values and the cancellation of the request
+	r.ContextWithFallback = true
 
 	// ToDo: make logger and recovery middleware configurable
 	r.Use(gin.Logger(), gin.Recovery())
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skroczek/go-simple-json-store/backend/fs"
+)
+
+// blockingBackend blocks writes until the context of the request is done, like a lock wait
+type blockingBackend struct {
+	*fs.Memory
+}
+
+func (b blockingBackend) Write(ctx context.Context, path string, data []byte) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(5 * time.Second):
+		return b.Memory.Write(ctx, path, data)
+	}
+}
+
+func TestServer_RequestContext(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := NewServer(WithBackend(blockingBackend{fs.NewMemory()}))
+	engine := s.prepareEngine()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodPut, "/foo.json", strings.NewReader(`{}`)).WithContext(ctx)
+	start := time.Now()
+	engine.ServeHTTP(httptest.NewRecorder(), req)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("request took %s, the backend did not see that the client went away", elapsed)
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/skroczek/go-simple-json-store/backend"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TenantFunc returns the tenant for the authenticated user, which the authentication middleware stored as "user"
+type TenantFunc func(user interface{}) (string, error)
+
+// setTenant stores the tenant of the request for backend.TenantPrefix
+func setTenant(c *gin.Context, tenant string) {
+	if !backend.ValidTenant(tenant) {
+		_ = c.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid tenant %q", tenant))
+		return
+	}
+	c.Request = c.Request.WithContext(backend.WithTenant(c.Request.Context(), tenant))
+	c.Next()
+}
+
+// WithTenantFromHost takes the tenant of a request from its Host header, without the port. Combined with a
+// backend.Chroot using backend.TenantPrefix, every host name gets its own isolated store.
+func WithTenantFromHost() Options {
+	return func(s *Server) {
+		s.AddRouterOption(func(r *gin.Engine) {
+			r.Use(func(c *gin.Context) {
+				host := c.Request.Host
+				if h, _, err := net.SplitHostPort(host); err == nil {
+					host = h
+				}
+				setTenant(c, strings.ToLower(host))
+			})
+		})
+	}
+}
+
+// WithTenantFromUser takes the tenant of a request from the authenticated user. It must be added after the
+// authentication middleware, requests without a user are rejected.
+func WithTenantFromUser(tenant TenantFunc) Options {
+	return func(s *Server) {
+		s.AddRouterOption(func(r *gin.Engine) {
+			r.Use(func(c *gin.Context) {
+				user, ok := c.Get("user")
+				if !ok {
+					_ = c.AbortWithError(http.StatusUnauthorized, fmt.Errorf("no authenticated user"))
+					return
+				}
+				name, err := tenant(user)
+				if err != nil {
+					_ = c.AbortWithError(http.StatusForbidden, err)
+					return
+				}
+				setTenant(c, name)
+			})
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skroczek/go-simple-json-store/backend"
+	"github.com/skroczek/go-simple-json-store/backend/fs"
+)
+
+func TestWithTenantFromHost(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	be := fs.NewMemory()
+	s := NewServer(
+		WithBackend(be),
+		WithBackend(backend.NewChrootFunc(backend.TenantPrefix("/tenants"))),
+		WithTenantFromHost(),
+	)
+	engine := s.prepareEngine()
+
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "http://acme.example:8080/foo.json", strings.NewReader(`{"a":1}`)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("PUT status = %d, body %s", rec.Code, rec.Body)
+	}
+	if data, err := be.Get(context.TODO(), "/tenants/acme.example/foo.json"); err != nil || string(data) != `{"a":1}` {
+		t.Errorf("document of tenant got = %s, %v", data, err)
+	}
+
+	rec = httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://other.example/foo.json", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET of other tenant status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/skroczek/go-simple-json-store/backend"
+	"github.com/skroczek/go-simple-json-store/errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const trashSuffix = "__trash.json"
+const optionPath = "path"
+
+func trashHandler(c *gin.Context, be backend.TrashBackend) {
+	urlPath := c.Request.URL.Path
+	dir := urlPath[0 : len(urlPath)-len(trashSuffix)]
+	path, hasPath := c.GetQuery(optionPath)
+	switch c.Request.Method {
+	case http.MethodGet:
+		list, err := be.Trashed(c, dir)
+		if err != nil {
+			abortWithBackendError(c, err)
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusOK, list)
+	case http.MethodPost:
+		if !hasPath {
+			_ = c.AbortWithError(http.StatusBadRequest, errors.ErrorInvalidPath)
+			return
+		}
+		if err := be.RestoreTrashed(c, path); err != nil {
+			if err == errors.ErrorAlreadyExists {
+				_ = c.AbortWithError(http.StatusConflict, err)
+				return
+			}
+			abortWithBackendError(c, err)
+			return
+		}
+		c.AbortWithStatus(http.StatusCreated)
+	case http.MethodDelete:
+		if !hasPath {
+			path = dir
+		}
+		if err := be.Purge(c, path); err != nil {
+			abortWithBackendError(c, err)
+			return
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	default:
+		_ = c.AbortWithError(http.StatusMethodNotAllowed, errMethodNotAllowed)
+	}
+}
+
+// WithTrash adds the magic URL __trash.json for a backend.TrashBackend. GET lists the trashed documents below the
+// directory, POST ?path=/foo.json restores a document and DELETE purges a single document (?path=/foo.json) or all
+// trashed documents below the directory.
+func WithTrash() Options {
+	return func(s *Server) {
+		if b, ok := s.Backend.(backend.TrashBackend); ok {
+			s.AddRouterOption(func(r *gin.Engine) {
+				r.Use(func(c *gin.Context) {
+					if strings.HasSuffix(c.Request.URL.Path, trashSuffix) {
+						trashHandler(c, b)
+						return
+					}
+					c.Next()
+				})
+			})
+		} else {
+			log.Panicf("Error: backend does not implement backend.TrashBackend")
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/skroczek/go-simple-json-store/backend"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+const treeSuffix = "__tree.json"
+
+func getTreeHandler(c *gin.Context, be backend.Backend) {
+	urlPath := c.Request.URL.Path
+	dir := strings.TrimSuffix(urlPath[0:len(urlPath)-len(treeSuffix)], "/")
+	_, withoutExtension := c.GetQuery(optionWithoutExtension)
+	data := make([]string, 0)
+	err := backend.Walk(c, be, dir, func(path string) error {
+		name := strings.TrimPrefix(path, dir+"/")
+		if withoutExtension {
+			name = strings.TrimSuffix(name, filepath.Ext(name))
+		}
+		data = append(data, name)
+		return nil
+	})
+	if err != nil {
+		abortWithBackendError(c, err)
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusOK, data)
+}
+
+// WithTree adds the magic URL __tree.json, which returns the paths of all documents below the directory, relative to
+// it. Subdirectories are included if the backend implements backend.Walker or backend.FileBackend.
+func WithTree() Options {
+	return func(s *Server) {
+		s.AddRouterOption(func(r *gin.Engine) {
+			r.Use(func(c *gin.Context) {
+				if strings.HasSuffix(c.Request.URL.Path, treeSuffix) {
+					if c.Request.Method != http.MethodGet {
+						_ = c.AbortWithError(http.StatusMethodNotAllowed, errMethodNotAllowed)
+						return
+					}
+					getTreeHandler(c, s.Backend)
+					return
+				}
+				c.Next()
+			})
+		})
+	}
+}